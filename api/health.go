@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCheckTimeout = 2 * time.Second
+
+type readinessCheck struct {
+	name    string
+	timeout time.Duration
+	fn      func(ctx context.Context) error
+}
+
+type checkResult struct {
+	Status     string `json:"status"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+// AddReadinessCheck registers fn to run on every /readyz, it fails when it does not
+// return within timeout, or 2 seconds when timeout is 0.
+func (server *Server) AddReadinessCheck(name string, timeout time.Duration, fn func(ctx context.Context) error) {
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	server.checks = append(server.checks, readinessCheck{name: name, timeout: timeout, fn: fn})
+}
+
+// Healthz reports that the process serves requests, for liveness probes.
+func (server *Server) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+		"time":   time.Now().Unix(),
+	})
+}
+
+// Readyz runs the readiness checks concurrently and answers 503 when one fails, or
+// when the server is draining.
+func (server *Server) Readyz(c *gin.Context) {
+	if server.IsDraining() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status": "draining",
+		})
+		return
+	}
+	results := make(map[string]checkResult, len(server.checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range server.checks {
+		wg.Add(1)
+		go func(check readinessCheck) {
+			defer wg.Done()
+			result := runCheck(c.Request.Context(), check)
+			mu.Lock()
+			results[check.name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+	status, code := "ok", http.StatusOK
+	for _, result := range results {
+		if result.Status != "ok" {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, gin.H{
+		"status": status,
+		"checks": results,
+	})
+}
+
+// runCheck runs check in its own goroutine, so that a call ignoring ctx, like
+// redis.Redis.Ping, does not hold the probe past the timeout.
+func runCheck(ctx context.Context, check readinessCheck) checkResult {
+	ctx, cancel := context.WithTimeout(ctx, check.timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check.fn(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := checkResult{Status: "ok", DurationMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		result.Status = "error"
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// ServerConfig holds the timeouts of the HTTP server, in seconds, and its proxies.
+type ServerConfig struct {
+	ReadTimeout       int `mapstructure:"read_timeout"`
+	ReadHeaderTimeout int `mapstructure:"read_header_timeout"`
+	WriteTimeout      int `mapstructure:"write_timeout"`
+	IdleTimeout       int `mapstructure:"idle_timeout"`
+	// DrainPeriod is how long the server keeps serving after a stop signal while it
+	// reports not ready, so that load balancers stop sending it requests.
+	DrainPeriod int `mapstructure:"drain_period"`
+	// ShutdownTimeout bounds the wait for requests in flight and the shutdown hooks.
+	ShutdownTimeout int `mapstructure:"shutdown_timeout"`
+	// TrustedProxies are the IPs or CIDRs whose X-Forwarded-For and X-Real-IP headers
+	// give the client ip, the peer address is used otherwise.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// MetricsAddress is the listen address of /metrics, apart from the API so that it
+	// can stay private, for example 127.0.0.1:9100. Metrics are not served when empty.
+	MetricsAddress string `mapstructure:"metrics_address"`
+}
+
+func (config ServerConfig) withDefaults() ServerConfig {
+	if config.ReadTimeout <= 0 {
+		config.ReadTimeout = 30
+	}
+	if config.ReadHeaderTimeout <= 0 {
+		config.ReadHeaderTimeout = 10
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = 60
+	}
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = 120
+	}
+	if config.DrainPeriod < 0 {
+		config.DrainPeriod = 0
+	}
+	if config.ShutdownTimeout <= 0 {
+		config.ShutdownTimeout = 30
+	}
+	return config
+}
+
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// OnShutdown registers fn to run once the server stopped serving. Hooks run in the
+// order they are registered, so register the users of a resource before it.
+func (server *Server) OnShutdown(name string, fn func(ctx context.Context) error) {
+	server.hooks = append(server.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// IsDraining reports whether the server received a stop signal.
+func (server *Server) IsDraining() bool {
+	return atomic.LoadInt32(&server.draining) == 1
+}
+
+func (server *Server) newHttpServer(port string, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{
+		Addr:              ":" + port,
+		Handler:           server.Engine,
+		TLSConfig:         tlsConfig,
+		ReadTimeout:       time.Duration(server.config.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: time.Duration(server.config.ReadHeaderTimeout) * time.Second,
+		WriteTimeout:      time.Duration(server.config.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(server.config.IdleTimeout) * time.Second,
+	}
+}
+
+// run serves until SIGINT or SIGTERM, or until it fails to serve, then drains the
+// requests in flight and runs the shutdown hooks before it returns.
+func (server *Server) run(httpServer *http.Server, mode string) {
+	errServe := make(chan error, 1)
+	go func() {
+		if httpServer.TLSConfig != nil {
+			errServe <- httpServer.ListenAndServeTLS("", "")
+		} else {
+			errServe <- httpServer.ListenAndServe()
+		}
+	}()
+	log.Infof("service %v listening %son port %v", serviceName, mode, httpServer.Addr[1:])
+	metricsServer := server.startMetrics()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	select {
+	case err := <-errServe:
+		log.WithError(err).Error("failed to start service")
+	case sig := <-signals:
+		log.Infof("service %v received %v, shutting down", serviceName, sig)
+		atomic.StoreInt32(&server.draining, 1)
+		if server.config.DrainPeriod > 0 {
+			select {
+			case <-time.After(time.Duration(server.config.DrainPeriod) * time.Second):
+			case <-signals:
+				log.Info("second signal received, skipping the drain period")
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(server.config.ShutdownTimeout)*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.WithError(err).Error("failed to drain requests in flight")
+		} else if err := <-errServe; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("failed to stop service")
+		}
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Close(); err != nil {
+			log.WithError(err).Error("failed to stop metrics")
+		}
+	}
+	server.shutdown()
+}
+
+// startMetrics serves /metrics on MetricsAddress, it returns nil when there is none.
+func (server *Server) startMetrics() *http.Server {
+	if len(server.config.MetricsAddress) < 1 {
+		return nil
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	metricsServer := &http.Server{
+		Addr:              server.config.MetricsAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: time.Duration(server.config.ReadHeaderTimeout) * time.Second,
+	}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithError(err).Error("failed to serve metrics")
+		}
+	}()
+	log.Infof("service %v serving metrics on %v", serviceName, server.config.MetricsAddress)
+	return metricsServer
+}
+
+func (server *Server) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(server.config.ShutdownTimeout)*time.Second)
+	defer cancel()
+	for _, hook := range server.hooks {
+		if err := hook.fn(ctx); err != nil {
+			log.WithError(err).Errorf("shutdown of %s failed", hook.name)
+		} else {
+			log.Infof("shutdown of %s done", hook.name)
+		}
+	}
+	log.Infof("service %v stopped", serviceName)
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests served, by method, route and status.",
+	}, []string{"method", "route", "status"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "Latency of the HTTP requests, by method, route and status.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"method", "route", "status"})
+)
+
+// MetricsMiddleware counts the requests and their latency by route template, so that
+// path parameters do not blow up the label values. Requests matching no route are
+// counted under "unmatched", and requests that panic as 500 before gin.Recovery
+// answers them.
+func MetricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		defer func() {
+			status := c.Writer.Status()
+			err := recover()
+			if err != nil {
+				status = http.StatusInternalServerError
+			}
+			route := c.FullPath()
+			if len(route) < 1 {
+				route = "unmatched"
+			}
+			code := strconv.Itoa(status)
+			httpRequestsTotal.WithLabelValues(c.Request.Method, route, code).Inc()
+			httpRequestDuration.WithLabelValues(c.Request.Method, route, code).Observe(time.Since(start).Seconds())
+			if err != nil {
+				panic(err)
+			}
+		}()
+		c.Next()
+	}
+}
@@ -2,11 +2,12 @@ package api
 
 import (
 	authMdw "callcenter-api/middleware/auth"
+	"callcenter-api/middleware/auth/goauth"
+	"crypto/tls"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -15,13 +16,23 @@ const (
 )
 
 type Server struct {
-	Engine *gin.Engine
+	Engine   *gin.Engine
+	config   ServerConfig
+	hooks    []shutdownHook
+	checks   []readinessCheck
+	draining int32
 }
 
-func NewServer() *Server {
+func NewServer(config ServerConfig) (*Server, error) {
 	engine := gin.New()
+	// without trusted proxies, c.ClientIP is the peer address and X-Forwarded-For is
+	// ignored, so that clients can not pick the ip failed logins are counted for
+	if err := engine.SetTrustedProxies(config.TrustedProxies); err != nil {
+		return nil, err
+	}
 	authMdw.SetupGoGuardian()
 	engine.Use(gin.Recovery())
+	engine.Use(MetricsMiddleware())
 	engine.Use(CORSMiddleware())
 	engine.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
@@ -30,16 +41,21 @@ func NewServer() *Server {
 			"time":    time.Now().Unix(),
 		})
 	})
+	engine.GET("/.well-known/jwks.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, goauth.JWKS())
+	})
 
-	server := &Server{Engine: engine}
-	return server
+	server := &Server{Engine: engine, config: config.withDefaults()}
+	engine.GET("/healthz", server.Healthz)
+	engine.GET("/readyz", server.Readyz)
+	return server, nil
 }
 
 func CORSMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-API-Key, X-MFA-Token, X-Tenant-Uuid")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(200)
@@ -50,13 +66,10 @@ func CORSMiddleware() gin.HandlerFunc {
 }
 
 func (server *Server) Start(port string) {
-	v := make(chan struct{})
-	go func() {
-		if err := server.Engine.Run(":" + port); err != nil {
-			log.WithError(err).Error("failed to start service")
-			close(v)
-		}
-	}()
-	log.Infof("service %v listening on port %v", serviceName, port)
-	<-v
+	server.run(server.newHttpServer(port, nil), "")
+}
+
+// StartTLS is Start over HTTPS, see NewTLSConfig.
+func (server *Server) StartTLS(port string, tlsConfig *tls.Config) {
+	server.run(server.newHttpServer(port, tlsConfig), "with tls ")
 }
@@ -0,0 +1,55 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig makes the server serve HTTPS. With a ClientCaFile the clients may present
+// a certificate signed by one of its CAs, and must when RequireClientCert is set.
+type TLSConfig struct {
+	CertFile          string `mapstructure:"cert_file"`
+	KeyFile           string `mapstructure:"key_file"`
+	ClientCaFile      string `mapstructure:"client_ca_file"`
+	RequireClientCert bool   `mapstructure:"require_client_cert"`
+}
+
+func (config TLSConfig) Enabled() bool {
+	return len(config.CertFile) > 0
+}
+
+// NewTLSConfig loads the certificate of the server and the client CA pool.
+func NewTLSConfig(config TLSConfig) (*tls.Config, error) {
+	if len(config.CertFile) < 1 || len(config.KeyFile) < 1 {
+		return nil, errors.New("tls needs cert_file and key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load tls certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if len(config.ClientCaFile) > 0 {
+		pem, err := os.ReadFile(config.ClientCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("read tls client_ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("tls client_ca_file has no valid certificate")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if config.RequireClientCert {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	} else if config.RequireClientCert {
+		return nil, errors.New("tls require_client_cert needs client_ca_file")
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,235 @@
+package v1
+
+import (
+	"callcenter-api/common/log"
+	"callcenter-api/common/response"
+	authMdw "callcenter-api/middleware/auth"
+	"callcenter-api/middleware/auth/goauth"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Auth struct {
+}
+
+func NewAuth(engine *gin.Engine) {
+	handler := &Auth{}
+	Group := engine.Group("v1/auth")
+	{
+		Group.POST("logout", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.Logout)
+		Group.DELETE("users/:id/tokens", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.RevokeUserTokens)
+		Group.GET("sessions", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.GetSessions)
+		Group.DELETE("sessions/:session_id", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.RevokeSession)
+		Group.GET("users/:id/sessions", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.GetUserSessions)
+		Group.DELETE("users/:id/sessions/:session_id", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.RevokeUserSession)
+		Group.GET("users/:id/lock", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.GetUserLock)
+		Group.DELETE("users/:id/lock", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.UnlockUser)
+		Group.DELETE("ips/:ip/lock", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.UnlockIp)
+	}
+}
+
+func (handler *Auth) Logout(c *gin.Context) {
+	token := c.GetHeader("Authorization")
+	if !strings.HasPrefix(token, "Bearer ") {
+		c.JSON(response.BadRequestMsg("token is required"))
+		return
+	}
+	token = strings.TrimSpace(strings.TrimPrefix(token, "Bearer "))
+	if len(token) < 1 {
+		c.JSON(response.BadRequestMsg("token is required"))
+		return
+	}
+	if err := authMdw.RevokeToken(c, token); err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.NewOKResponse(nil))
+}
+
+func (handler *Auth) RevokeUserTokens(c *gin.Context) {
+	userId := c.Param("id")
+	if !checkManagedUser(c, userId) {
+		return
+	}
+	if err := goauth.GoAuthClient.RevokeAllForUser(c, userId); err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	adminId, _ := authMdw.GetUserId(c)
+	log.Infof("user %s revoked all tokens of user %s", adminId, userId)
+	c.JSON(response.NewOKResponse(nil))
+}
+
+func (handler *Auth) GetSessions(c *gin.Context) {
+	user, ok := authMdw.GetUser(c)
+	if !ok {
+		c.JSON(response.Unauthorized())
+		return
+	}
+	sessions, err := goauth.GoAuthClient.ListSessions(c, user.Id)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.NewOKResponse(sessions))
+}
+
+func (handler *Auth) RevokeSession(c *gin.Context) {
+	user, ok := authMdw.GetUser(c)
+	if !ok {
+		c.JSON(response.Unauthorized())
+		return
+	}
+	handler.revokeSession(c, user.Id, c.Param("session_id"))
+}
+
+func (handler *Auth) GetUserSessions(c *gin.Context) {
+	userId := c.Param("id")
+	if !checkManagedUser(c, userId) {
+		return
+	}
+	sessions, err := goauth.GoAuthClient.ListSessions(c, userId)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.NewOKResponse(sessions))
+}
+
+func (handler *Auth) RevokeUserSession(c *gin.Context) {
+	userId := c.Param("id")
+	if !checkManagedUser(c, userId) {
+		return
+	}
+	handler.revokeSession(c, userId, c.Param("session_id"))
+}
+
+func (handler *Auth) revokeSession(c *gin.Context, userId, sessionId string) {
+	if len(sessionId) < 1 {
+		c.JSON(response.BadRequestMsg("session id is required"))
+		return
+	}
+	err := goauth.GoAuthClient.RevokeSession(c, userId, sessionId)
+	if errors.Is(err, goauth.ErrSessionNotFound) {
+		c.JSON(response.NotFoundMsg("session is not found"))
+		return
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	log.Infof("session %s of user %s is ended", sessionId, userId)
+	c.JSON(response.NewOKResponse(nil))
+}
+
+// checkManagedUser writes the response and returns false when the current user may
+// not manage userId. A non superadmin only manages users of its own domain, and
+// nobody manages a user of a higher level.
+func checkManagedUser(c *gin.Context, userId string) bool {
+	if len(userId) < 1 {
+		c.JSON(response.BadRequestMsg("user id is required"))
+		return false
+	}
+	user, ok := authMdw.GetUser(c)
+	if !ok {
+		c.JSON(response.Unauthorized())
+		return false
+	}
+	domainId := ""
+	if user.Level != authMdw.SUPERADMIN {
+		domainId, _ = authMdw.GetUserDomainId(c)
+	}
+	level, err := authMdw.GetUserLevelInDomain(c, userId, domainId)
+	if errors.Is(err, authMdw.ErrUserNotFound) {
+		c.JSON(response.NotFoundMsg("user is not found"))
+		return false
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return false
+	}
+	if authMdw.LevelRank(level) > authMdw.LevelRank(user.Level) {
+		c.JSON(response.Forbidden())
+		return false
+	}
+	return true
+}
+
+func (handler *Auth) GetUserLock(c *gin.Context) {
+	account, ok := handler.getManagedAccount(c)
+	if !ok {
+		return
+	}
+	status, err := authMdw.LoginGuard.Status(c, account)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.OK(status))
+}
+
+func (handler *Auth) UnlockUser(c *gin.Context) {
+	account, ok := handler.getManagedAccount(c)
+	if !ok {
+		return
+	}
+	if err := authMdw.LoginGuard.Unlock(c, account); err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	userId, _ := authMdw.GetUserId(c)
+	log.Infof("user %s unlocked account %s", userId, account)
+	c.JSON(response.NewOKResponse(nil))
+}
+
+func (handler *Auth) UnlockIp(c *gin.Context) {
+	if authMdw.LoginGuard == nil {
+		c.JSON(response.ServiceUnavailableMsg("login guard is not enabled"))
+		return
+	}
+	ip := c.Param("ip")
+	if net.ParseIP(ip) == nil {
+		c.JSON(response.BadRequestMsg("ip is invalid"))
+		return
+	}
+	if err := authMdw.LoginGuard.UnlockIp(c, ip); err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	userId, _ := authMdw.GetUserId(c)
+	log.Infof("user %s unlocked ip %s", userId, ip)
+	c.JSON(response.NewOKResponse(nil))
+}
+
+// getManagedAccount returns the login account of the user in the path after the
+// same checks as checkManagedUser.
+func (handler *Auth) getManagedAccount(c *gin.Context) (string, bool) {
+	if authMdw.LoginGuard == nil {
+		c.JSON(response.ServiceUnavailableMsg("login guard is not enabled"))
+		return "", false
+	}
+	userId := c.Param("id")
+	if !checkManagedUser(c, userId) {
+		return "", false
+	}
+	account, err := authMdw.GetUserAccount(c, userId)
+	if errors.Is(err, authMdw.ErrUserNotFound) {
+		c.JSON(response.NotFoundMsg("user is not found"))
+		return "", false
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return "", false
+	}
+	return account, true
+}
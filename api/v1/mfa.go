@@ -0,0 +1,157 @@
+package v1
+
+import (
+	"callcenter-api/common/log"
+	"callcenter-api/common/response"
+	authMdw "callcenter-api/middleware/auth"
+	"callcenter-api/model"
+	"callcenter-api/service"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Mfa struct {
+	mfaService service.IMfa
+}
+
+func NewMfa(engine *gin.Engine, mfaService service.IMfa) {
+	handler := &Mfa{
+		mfaService: mfaService,
+	}
+	Group := engine.Group("v1/auth")
+	{
+		Group.GET("mfa", authMdw.MfaPendingMiddleware(), authMdw.Authorize(), handler.GetStatus)
+		Group.POST("mfa/setup", authMdw.MfaPendingMiddleware(), authMdw.Authorize(), handler.Setup)
+		Group.POST("mfa/confirm", authMdw.MfaPendingMiddleware(), authMdw.Authorize(), handler.Confirm)
+		Group.DELETE("mfa", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.Disable)
+		Group.POST("mfa/recovery-codes", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.RegenerateRecoveryCodes)
+		Group.DELETE("users/:id/mfa", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.ResetUser)
+	}
+}
+
+func (handler *Mfa) GetStatus(c *gin.Context) {
+	user, ok := authMdw.GetUser(c)
+	if !ok {
+		c.JSON(response.Unauthorized())
+		return
+	}
+	status, err := handler.mfaService.Status(c, user.Id, user.Level)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.OK(status))
+}
+
+func (handler *Mfa) Setup(c *gin.Context) {
+	user, ok := authMdw.GetUser(c)
+	if !ok {
+		c.JSON(response.Unauthorized())
+		return
+	}
+	account := user.Name
+	if len(user.DomainName) > 0 {
+		account += "@" + user.DomainName
+	}
+	setup, err := handler.mfaService.Setup(c, user.Id, account)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(response.OK(setup))
+}
+
+func (handler *Mfa) Confirm(c *gin.Context) {
+	user, ok := authMdw.GetUser(c)
+	if !ok {
+		c.JSON(response.Unauthorized())
+		return
+	}
+	var body model.MfaRequest
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.Otp) < 1 {
+		c.JSON(response.BadRequestMsg("otp is required"))
+		return
+	}
+	codes, err := handler.mfaService.Confirm(c, user.Id, body.Otp)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+	log.Infof("second factor of user %s is enrolled", user.Id)
+	c.JSON(response.OK(map[string]interface{}{
+		"recovery_codes": codes,
+	}))
+}
+
+func (handler *Mfa) Disable(c *gin.Context) {
+	user, ok := authMdw.GetUser(c)
+	if !ok {
+		c.JSON(response.Unauthorized())
+		return
+	}
+	var body model.MfaRequest
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.Otp) < 1 {
+		c.JSON(response.BadRequestMsg("otp is required"))
+		return
+	}
+	if err := handler.mfaService.Disable(c, user.Id, user.Level, body.Otp); err != nil {
+		mfaError(c, err)
+		return
+	}
+	log.Infof("second factor of user %s is disabled", user.Id)
+	c.JSON(response.NewOKResponse(nil))
+}
+
+func (handler *Mfa) RegenerateRecoveryCodes(c *gin.Context) {
+	user, ok := authMdw.GetUser(c)
+	if !ok {
+		c.JSON(response.Unauthorized())
+		return
+	}
+	var body model.MfaRequest
+	if err := c.ShouldBindJSON(&body); err != nil || len(body.Otp) < 1 {
+		c.JSON(response.BadRequestMsg("otp is required"))
+		return
+	}
+	codes, err := handler.mfaService.RegenerateRecoveryCodes(c, user.Id, body.Otp)
+	if err != nil {
+		mfaError(c, err)
+		return
+	}
+	c.JSON(response.OK(map[string]interface{}{
+		"recovery_codes": codes,
+	}))
+}
+
+// ResetUser removes the second factor of a user who lost its device, it enrolls
+// again at its next login.
+func (handler *Mfa) ResetUser(c *gin.Context) {
+	userId := c.Param("id")
+	if !checkManagedUser(c, userId) {
+		return
+	}
+	if err := handler.mfaService.Reset(c, userId); err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	adminId, _ := authMdw.GetUserId(c)
+	log.Infof("second factor of user %s is reset by %s", userId, adminId)
+	c.JSON(response.NewOKResponse(nil))
+}
+
+func mfaError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, authMdw.ErrMfaInvalidCode):
+		c.JSON(response.BadRequestMsg(err.Error()))
+	case errors.Is(err, service.ErrMfaNotEnrolled):
+		c.JSON(response.NotFoundMsg(err.Error()))
+	case errors.Is(err, service.ErrMfaAlreadyEnrolled), errors.Is(err, service.ErrMfaMandatory):
+		c.JSON(response.BadRequestMsg(err.Error()))
+	default:
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+	}
+}
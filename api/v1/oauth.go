@@ -0,0 +1,318 @@
+package v1
+
+import (
+	"callcenter-api/common/log"
+	"callcenter-api/common/response"
+	authMdw "callcenter-api/middleware/auth"
+	"callcenter-api/middleware/auth/goauth"
+	"callcenter-api/service"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuth struct {
+}
+
+func NewOAuth(engine *gin.Engine) {
+	handler := &OAuth{}
+	Group := engine.Group("oauth")
+	{
+		Group.POST("token", handler.Token)
+		Group.POST("introspect", handler.Introspect)
+	}
+}
+
+// Token implements the token endpoint of RFC 6749. Clients are checked against the
+// client registry of goauth.GoAuthClient.
+func (handler *OAuth) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	clientId, clientSecret, isBasic := clientCredentials(c)
+	if len(clientId) < 1 {
+		c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_request", "client_id is required"))
+		return
+	}
+	scopes := strings.Fields(c.PostForm("scope"))
+	var client goauth.AuthClient
+	var err error
+	switch grantType := c.PostForm("grant_type"); grantType {
+	case goauth.GRANT_CLIENT_CREDENTIALS:
+		client, err = goauth.GoAuthClient.ClientCredential(c, goauth.AuthClient{
+			ClienId:      clientId,
+			ClientSecret: clientSecret,
+			GrantType:    grantType,
+			UserId:       clientId,
+			Scopes:       scopes,
+			UserData: map[string]interface{}{
+				"username":  clientId,
+				"client_id": clientId,
+			},
+			UserAgent: c.Request.UserAgent(),
+			IpAddress: c.ClientIP(),
+		}, false)
+	case goauth.GRANT_PASSWORD:
+		username := c.PostForm("username")
+		password := c.PostForm("password")
+		if len(username) < 1 || len(password) < 1 {
+			c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_request", "username and password are required"))
+			return
+		}
+		if _, err := goauth.GoAuthClient.AuthorizeGrant(c, clientId, clientSecret, grantType); err != nil {
+			oauthError(c, err, isBasic)
+			return
+		}
+		authMdw.SetClientIP(c)
+		user, errAuth := authMdw.ValidateUserPassword(c, c.Request, username, password)
+		if locked, ok := authMdw.AsLockedError(errAuth); ok {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			c.JSON(response.Locked(int(math.Ceil(locked.RetryAfter.Seconds()))))
+			return
+		} else if errAuth != nil {
+			c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_grant", "username or password is not valid"))
+			return
+		}
+		authClient := goauth.AuthClient{
+			ClienId:      clientId,
+			ClientSecret: clientSecret,
+			GrantType:    grantType,
+			UserId:       user.Id,
+			Scopes:       scopes,
+			UserData: map[string]interface{}{
+				"username":    user.Name,
+				"domain_uuid": user.DomainId,
+				"domain_name": user.DomainName,
+				"level":       user.Level,
+			},
+			DeviceId:  c.PostForm("device_id"),
+			UserAgent: c.Request.UserAgent(),
+			IpAddress: c.ClientIP(),
+		}
+		if authMdw.Mfa != nil {
+			required, enrolled, errMfa := authMdw.Mfa.Check(c, user.Id, user.Level)
+			if errMfa != nil {
+				oauthError(c, errMfa, isBasic)
+				return
+			} else if required {
+				handler.mfaRequired(c, authClient, enrolled, isBasic)
+				return
+			}
+		}
+		client, err = goauth.GoAuthClient.ClientCredential(c, authClient, true)
+	case goauth.GRANT_MFA_OTP:
+		pending, errMfa := handler.verifyMfa(c, clientId, clientSecret, isBasic)
+		if errMfa != nil {
+			return
+		}
+		client, err = goauth.GoAuthClient.ClientCredential(c, goauth.AuthClient{
+			ClienId:      clientId,
+			ClientSecret: clientSecret,
+			GrantType:    goauth.GRANT_PASSWORD,
+			UserId:       pending.UserId,
+			Scopes:       pending.Scopes,
+			UserData:     pending.UserData,
+			DeviceId:     pending.DeviceId,
+			UserAgent:    c.Request.UserAgent(),
+			IpAddress:    c.ClientIP(),
+		}, true)
+	case goauth.GRANT_REFRESH_TOKEN:
+		refreshToken := c.PostForm("refresh_token")
+		if len(refreshToken) < 1 {
+			c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_request", "refresh_token is required"))
+			return
+		}
+		if _, err := goauth.GoAuthClient.AuthenticateClient(c, clientId, clientSecret); err != nil {
+			oauthError(c, err, isBasic)
+			return
+		}
+		client, err = goauth.GoAuthClient.RefreshToken(c, clientId, refreshToken)
+		if err == nil && client.UserId != "" && client.UserId != clientId {
+			if errAccount := authMdw.CheckAccountEnabled(c, &authMdw.GoAuthUser{Id: client.UserId}); errAccount != nil {
+				log.Errorf("refresh token refused for user %s: %v", client.UserId, errAccount)
+				if errRevoke := goauth.GoAuthClient.Revoke(c, client.Token); errRevoke != nil {
+					log.Error(errRevoke)
+				}
+				c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_grant", errAccount.Error()))
+				return
+			}
+		}
+	case "":
+		c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_request", "grant_type is required"))
+		return
+	default:
+		c.JSON(response.OAuthError(http.StatusBadRequest, "unsupported_grant_type", grantType+" is not supported"))
+		return
+	}
+	if err != nil {
+		oauthError(c, err, isBasic)
+		return
+	}
+	result := map[string]interface{}{
+		"access_token": client.Token,
+		"token_type":   client.TokenType,
+		"expires_in":   client.ExpiredIn,
+		"scope":        strings.Join(client.Scopes, " "),
+		"jwt":          client.JWT,
+	}
+	if len(client.RefreshToken) > 0 {
+		result["refresh_token"] = client.RefreshToken
+	}
+	if len(client.SessionId) > 0 {
+		result["session_id"] = client.SessionId
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// mfaRequired answers a password grant whose user has to pass its second factor with
+// an mfa_token, to send back with the code in an mfa_otp grant, or to enroll with.
+func (handler *OAuth) mfaRequired(c *gin.Context, client goauth.AuthClient, enrolled bool, isBasic bool) {
+	expiredIn := authMdw.Mfa.PendingExpiredIn()
+	mfaToken, err := goauth.NewMfaPendingToken(client, expiredIn)
+	if err != nil {
+		oauthError(c, err, isBasic)
+		return
+	}
+	description := "second factor is required"
+	if !enrolled {
+		description = "second factor must be enrolled"
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":             "mfa_required",
+		"error_description": description,
+		"mfa_token":         mfaToken,
+		"mfa_enrolled":      enrolled,
+		"expires_in":        expiredIn,
+	})
+}
+
+// verifyMfa checks the mfa_token and the otp, or recovery_code, of an mfa_otp grant
+// and returns the pending login. The response is written when it fails.
+func (handler *OAuth) verifyMfa(c *gin.Context, clientId, clientSecret string, isBasic bool) (goauth.AuthClient, error) {
+	mfaToken := c.PostForm("mfa_token")
+	code := c.PostForm("otp")
+	if len(code) < 1 {
+		code = c.PostForm("recovery_code")
+	}
+	if len(mfaToken) < 1 || len(code) < 1 {
+		c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_request", "mfa_token and otp are required"))
+		return goauth.AuthClient{}, goauth.ErrMfaTokenInvalid
+	}
+	if authMdw.Mfa == nil {
+		c.JSON(response.OAuthError(http.StatusBadRequest, "unsupported_grant_type", goauth.GRANT_MFA_OTP+" is not supported"))
+		return goauth.AuthClient{}, authMdw.ErrMfaInvalidCode
+	}
+	pending, err := goauth.ParseMfaPendingToken(mfaToken)
+	if err == nil && pending.ClienId != clientId {
+		err = goauth.ErrMfaTokenInvalid
+	}
+	if err != nil {
+		c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_grant", err.Error()))
+		return pending, err
+	}
+	if _, err := goauth.GoAuthClient.AuthorizeGrant(c, clientId, clientSecret, goauth.GRANT_PASSWORD); err != nil {
+		oauthError(c, err, isBasic)
+		return pending, err
+	}
+	authMdw.SetClientIP(c)
+	err = authMdw.VerifyLoginMfa(c, c.Request, pending, code)
+	if locked, ok := authMdw.AsLockedError(err); ok {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		c.JSON(response.Locked(int(math.Ceil(locked.RetryAfter.Seconds()))))
+		return pending, err
+	} else if errors.Is(err, authMdw.ErrMfaInvalidCode) || errors.Is(err, service.ErrMfaNotEnrolled) {
+		c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_grant", err.Error()))
+		return pending, err
+	} else if err != nil {
+		oauthError(c, err, isBasic)
+		return pending, err
+	}
+	if err := authMdw.CheckAccountEnabled(c, &authMdw.GoAuthUser{Id: pending.UserId}); err != nil {
+		log.Errorf("second factor refused for user %s: %v", pending.UserId, err)
+		c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_grant", err.Error()))
+		return pending, err
+	}
+	return pending, nil
+}
+
+// Introspect implements token introspection. A request with a token form parameter
+// is answered as in RFC 7662 and must be made by a confidential client. Otherwise the
+// Authorization header itself is checked and the user is returned with 202 Accepted,
+// which is what GoAuthMiddleware expects from its authUrl.
+func (handler *OAuth) Introspect(c *gin.Context) {
+	token := c.PostForm("token")
+	if len(token) < 1 {
+		if len(c.GetHeader("Authorization")) < 1 {
+			c.JSON(response.Unauthorized())
+			return
+		}
+		user, err := authMdw.AuthenticateRequest(c.Request)
+		if err != nil {
+			log.Error(err)
+			c.JSON(response.Unauthorized())
+			return
+		}
+		c.JSON(http.StatusAccepted, user)
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	clientId, clientSecret, _ := clientCredentials(c)
+	if client, err := goauth.GoAuthClient.AuthenticateClient(c, clientId, clientSecret); err != nil || len(client.SecretHash) < 1 {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		c.JSON(response.OAuthError(http.StatusUnauthorized, "invalid_client", goauth.ErrInvalidClient.Error()))
+		return
+	}
+	user, client, err := authMdw.IntrospectToken(c, token)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"active":      true,
+		"scope":       strings.Join(client.Scopes, " "),
+		"client_id":   client.ClienId,
+		"username":    user.Name,
+		"token_type":  client.TokenType,
+		"exp":         client.ExpiredTime.Unix(),
+		"iat":         client.CreatedTime.Unix(),
+		"sub":         client.UserId,
+		"domain_id":   user.DomainId,
+		"domain_name": user.DomainName,
+		"level":       user.Level,
+	})
+}
+
+// clientCredentials reads the client credentials from HTTP Basic authentication,
+// or from the request body as allowed by RFC 6749 section 2.3.1.
+func clientCredentials(c *gin.Context) (string, string, bool) {
+	clientId, clientSecret, isBasic := c.Request.BasicAuth()
+	if !isBasic {
+		clientId = c.PostForm("client_id")
+		clientSecret = c.PostForm("client_secret")
+	}
+	return clientId, clientSecret, isBasic
+}
+
+func oauthError(c *gin.Context, err error, isBasic bool) {
+	switch {
+	case errors.Is(err, goauth.ErrInvalidClient):
+		if isBasic {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+			c.JSON(response.OAuthError(http.StatusUnauthorized, "invalid_client", err.Error()))
+		} else {
+			c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_client", err.Error()))
+		}
+	case errors.Is(err, goauth.ErrUnauthorizedClient):
+		c.JSON(response.OAuthError(http.StatusBadRequest, "unauthorized_client", err.Error()))
+	case errors.Is(err, goauth.ErrInvalidScope):
+		c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_scope", err.Error()))
+	case errors.Is(err, goauth.ErrRefreshTokenInvalid), errors.Is(err, goauth.ErrRefreshTokenExpired), errors.Is(err, goauth.ErrRefreshTokenUsed):
+		c.JSON(response.OAuthError(http.StatusBadRequest, "invalid_grant", err.Error()))
+	default:
+		log.Error(err)
+		c.JSON(response.OAuthError(http.StatusInternalServerError, "server_error", ""))
+	}
+}
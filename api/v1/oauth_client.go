@@ -0,0 +1,123 @@
+package v1
+
+import (
+	"callcenter-api/common/log"
+	"callcenter-api/common/response"
+	"callcenter-api/common/util"
+	authMdw "callcenter-api/middleware/auth"
+	"callcenter-api/model"
+	"callcenter-api/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OAuthClient struct {
+	oauthClientService service.IOAuthClient
+}
+
+func NewOAuthClient(engine *gin.Engine, oauthClientService service.IOAuthClient) {
+	handler := &OAuthClient{
+		oauthClientService: oauthClientService,
+	}
+	Group := engine.Group("v1/oauth/clients", authMdw.AuthMiddleware(), authMdw.Authorize())
+	{
+		Group.GET("", handler.GetClients)
+		Group.GET(":id", handler.GetClientById)
+		Group.POST("", handler.InsertClient)
+		Group.PUT(":id", handler.UpdateClient)
+		Group.DELETE(":id", handler.DeleteClient)
+		Group.POST(":id/secret", handler.RotateSecret)
+	}
+}
+
+func (handler *OAuthClient) GetClients(c *gin.Context) {
+	limit := util.ParseLimit(c.Query("limit"))
+	offset := util.ParseOffset(c.Query("offset"))
+	total, clients, err := handler.oauthClientService.GetClients(c, limit, offset)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.Pagination(clients, limit, offset, total))
+}
+
+func (handler *OAuthClient) GetClientById(c *gin.Context) {
+	client, err := handler.oauthClientService.GetClientById(c, c.Param("id"))
+	if err == service.ErrOAuthClientNotFound {
+		c.JSON(response.NotFoundMsg(err.Error()))
+		return
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.OK(client))
+}
+
+func (handler *OAuthClient) InsertClient(c *gin.Context) {
+	var body model.OAuthClientRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	client, secret, err := handler.oauthClientService.CreateClient(c, body)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"code":          http.StatusCreated,
+		"content":       "successfully",
+		"data":          client,
+		"client_secret": secret,
+	})
+}
+
+func (handler *OAuthClient) UpdateClient(c *gin.Context) {
+	var body model.OAuthClientRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	client, err := handler.oauthClientService.UpdateClient(c, c.Param("id"), body)
+	if err == service.ErrOAuthClientNotFound {
+		c.JSON(response.NotFoundMsg(err.Error()))
+		return
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	c.JSON(response.NewOKResponse(client))
+}
+
+func (handler *OAuthClient) DeleteClient(c *gin.Context) {
+	err := handler.oauthClientService.DeleteClient(c, c.Param("id"))
+	if err == service.ErrOAuthClientNotFound {
+		c.JSON(response.NotFoundMsg(err.Error()))
+		return
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.NewOKResponse(nil))
+}
+
+func (handler *OAuthClient) RotateSecret(c *gin.Context) {
+	secret, err := handler.oauthClientService.RotateSecret(c, c.Param("id"))
+	if err == service.ErrOAuthClientNotFound {
+		c.JSON(response.NotFoundMsg(err.Error()))
+		return
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.NewOKResponse(gin.H{
+		"client_secret": secret,
+	}))
+}
@@ -0,0 +1,42 @@
+package v1
+
+import (
+	authMdw "callcenter-api/middleware/auth"
+)
+
+// routePermissions declares who may call the routes guarded by authMdw.Authorize.
+var routePermissions = map[string]authMdw.RoutePermission{
+	"POST /v1/auth/logout":                           {},
+	"GET /v1/auth/sessions":                          {},
+	"DELETE /v1/auth/sessions/:session_id":           {},
+	"DELETE /v1/auth/users/:id/tokens":               {MinLevel: authMdw.LEADER, Scopes: []string{"auth:manage"}},
+	"GET /v1/auth/users/:id/sessions":                {MinLevel: authMdw.LEADER, Scopes: []string{"auth:manage"}},
+	"DELETE /v1/auth/users/:id/sessions/:session_id": {MinLevel: authMdw.LEADER, Scopes: []string{"auth:manage"}},
+	"GET /v1/auth/users/:id/lock":                    {MinLevel: authMdw.LEADER, Scopes: []string{"auth:manage"}},
+	"DELETE /v1/auth/users/:id/lock":                 {MinLevel: authMdw.LEADER, Scopes: []string{"auth:manage"}},
+	"DELETE /v1/auth/ips/:ip/lock":                   {MinLevel: authMdw.SUPERADMIN, Scopes: []string{"auth:manage"}},
+	"GET /v1/auth/mfa":                               {},
+	"POST /v1/auth/mfa/setup":                        {},
+	"POST /v1/auth/mfa/confirm":                      {},
+	"DELETE /v1/auth/mfa":                            {},
+	"POST /v1/auth/mfa/recovery-codes":               {},
+	"DELETE /v1/auth/users/:id/mfa":                  {MinLevel: authMdw.ADMIN, Scopes: []string{"auth:manage"}},
+	"GET /v1/oauth/clients":                          {MinLevel: authMdw.SUPERADMIN},
+	"GET /v1/oauth/clients/:id":                      {MinLevel: authMdw.SUPERADMIN},
+	"POST /v1/oauth/clients":                         {MinLevel: authMdw.SUPERADMIN},
+	"PUT /v1/oauth/clients/:id":                      {MinLevel: authMdw.SUPERADMIN},
+	"DELETE /v1/oauth/clients/:id":                   {MinLevel: authMdw.SUPERADMIN},
+	"POST /v1/oauth/clients/:id/secret":              {MinLevel: authMdw.SUPERADMIN},
+	"GET /v1/permissions":                            {Permissions: []string{"role.manage"}},
+	"GET /v1/roles":                                  {Permissions: []string{"role.manage"}},
+	"GET /v1/roles/:id":                              {Permissions: []string{"role.manage"}},
+	"POST /v1/roles":                                 {Permissions: []string{"role.manage"}},
+	"PUT /v1/roles/:id":                              {Permissions: []string{"role.manage"}},
+	"DELETE /v1/roles/:id":                           {Permissions: []string{"role.manage"}},
+	"GET /v1/users/:id/roles":                        {Permissions: []string{"role.manage"}},
+	"PUT /v1/users/:id/roles":                        {Permissions: []string{"role.manage"}},
+}
+
+func init() {
+	authMdw.RegisterRoutePermissions(routePermissions)
+}
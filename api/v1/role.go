@@ -0,0 +1,190 @@
+package v1
+
+import (
+	"callcenter-api/common/log"
+	"callcenter-api/common/response"
+	"callcenter-api/common/util"
+	authMdw "callcenter-api/middleware/auth"
+	"callcenter-api/model"
+	"callcenter-api/service"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type Role struct {
+	roleService service.IRole
+}
+
+func NewRole(engine *gin.Engine, roleService service.IRole) {
+	handler := &Role{
+		roleService: roleService,
+	}
+	engine.GET("v1/permissions", authMdw.AuthMiddleware(), authMdw.Authorize(), handler.GetPermissions)
+	Group := engine.Group("v1/roles", authMdw.AuthMiddleware(), authMdw.Authorize())
+	{
+		Group.GET("", handler.GetRoles)
+		Group.GET(":id", handler.GetRoleById)
+		Group.POST("", handler.InsertRole)
+		Group.PUT(":id", handler.UpdateRole)
+		Group.DELETE(":id", handler.DeleteRole)
+	}
+	userGroup := engine.Group("v1/users", authMdw.AuthMiddleware(), authMdw.Authorize())
+	{
+		userGroup.GET(":id/roles", handler.GetUserRoles)
+		userGroup.PUT(":id/roles", handler.UpdateUserRoles)
+	}
+}
+
+func (handler *Role) GetPermissions(c *gin.Context) {
+	permissions, err := handler.roleService.GetPermissions(c)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.OK(permissions))
+}
+
+func (handler *Role) GetRoles(c *gin.Context) {
+	domainId, _ := authMdw.GetUserDomainId(c)
+	limit := util.ParseLimit(c.Query("limit"))
+	offset := util.ParseOffset(c.Query("offset"))
+	total, roles, err := handler.roleService.GetRoles(c, domainId, limit, offset)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.Pagination(roles, limit, offset, total))
+}
+
+func (handler *Role) GetRoleById(c *gin.Context) {
+	domainId, _ := authMdw.GetUserDomainId(c)
+	role, err := handler.roleService.GetRoleById(c, domainId, c.Param("id"))
+	if err == service.ErrRoleNotFound {
+		c.JSON(response.NotFoundMsg(err.Error()))
+		return
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.OK(role))
+}
+
+func (handler *Role) InsertRole(c *gin.Context) {
+	var body model.RoleRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	domainId, _ := authMdw.GetUserDomainId(c)
+	if body.IsGlobal {
+		if level, _ := authMdw.GetUserLevel(c); level != authMdw.SUPERADMIN {
+			c.JSON(response.Forbidden())
+			return
+		}
+		domainId = ""
+	}
+	role, err := handler.roleService.CreateRole(c, domainId, body)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"code":    http.StatusCreated,
+		"content": "successfully",
+		"data":    role,
+	})
+}
+
+func (handler *Role) UpdateRole(c *gin.Context) {
+	var body model.RoleRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	domainId, _ := authMdw.GetUserDomainId(c)
+	level, _ := authMdw.GetUserLevel(c)
+	role, err := handler.roleService.UpdateRole(c, domainId, level == authMdw.SUPERADMIN, c.Param("id"), body)
+	if err == service.ErrRoleNotFound {
+		c.JSON(response.NotFoundMsg(err.Error()))
+		return
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	c.JSON(response.NewOKResponse(role))
+}
+
+func (handler *Role) DeleteRole(c *gin.Context) {
+	domainId, _ := authMdw.GetUserDomainId(c)
+	level, _ := authMdw.GetUserLevel(c)
+	err := handler.roleService.DeleteRole(c, domainId, level == authMdw.SUPERADMIN, c.Param("id"))
+	if err == service.ErrRoleNotFound {
+		c.JSON(response.NotFoundMsg(err.Error()))
+		return
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	c.JSON(response.NewOKResponse(nil))
+}
+
+func (handler *Role) GetUserRoles(c *gin.Context) {
+	userId := c.Param("id")
+	domainId, ok := handler.checkDomainUser(c, userId)
+	if !ok {
+		return
+	}
+	roles, err := handler.roleService.GetUserRoles(c, domainId, userId)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.OK(roles))
+}
+
+func (handler *Role) UpdateUserRoles(c *gin.Context) {
+	var body model.UserRoleRequest
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	}
+	userId := c.Param("id")
+	domainId, ok := handler.checkDomainUser(c, userId)
+	if !ok {
+		return
+	}
+	err := handler.roleService.SetUserRoles(c, domainId, userId, body.RoleUuids)
+	if err == service.ErrRoleNotFound {
+		c.JSON(response.BadRequestMsg(err.Error()))
+		return
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return
+	}
+	c.JSON(response.NewOKResponse(nil))
+}
+
+// checkDomainUser writes the response and returns false when userId is not a user of
+// the tenant the current user acts on.
+func (handler *Role) checkDomainUser(c *gin.Context, userId string) (string, bool) {
+	domainId, _ := authMdw.GetUserDomainId(c)
+	isExisted, err := authMdw.IsUserInDomain(c, userId, domainId)
+	if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		return "", false
+	} else if !isExisted {
+		c.JSON(response.NotFoundMsg("user is not found"))
+		return "", false
+	}
+	return domainId, true
+}
@@ -36,10 +36,12 @@ func (c *MemCache) SetTTL(key string, value interface{}, ttl time.Duration) erro
 func (c *MemCache) Get(key string) (interface{}, error) {
 	value, err := c.ttlCache.Get(key)
 	if err == ttlcache.ErrNotFound {
+		memMisses.Inc()
 		return nil, nil
 	} else if err != nil {
 		return nil, err
 	} else {
+		memHits.Inc()
 		return value, nil
 	}
 }
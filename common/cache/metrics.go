@@ -0,0 +1,18 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var cacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cache_requests_total",
+	Help: "Reads of MCache and RCache, by cache and result.",
+}, []string{"cache", "result"})
+
+var (
+	memHits     = cacheRequestsTotal.WithLabelValues("memory", "hit")
+	memMisses   = cacheRequestsTotal.WithLabelValues("memory", "miss")
+	redisHits   = cacheRequestsTotal.WithLabelValues("redis", "hit")
+	redisMisses = cacheRequestsTotal.WithLabelValues("redis", "miss")
+)
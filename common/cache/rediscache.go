@@ -32,7 +32,10 @@ func (c *RedisCache) SetTTL(key string, value interface{}, ttl time.Duration) er
 func (c *RedisCache) Get(key string) (string, error) {
 	value, err := c.cache.Get(ctx, key).Result()
 	if err == redis.Nil {
+		redisMisses.Inc()
 		return "", nil
+	} else if err == nil {
+		redisHits.Inc()
 	}
 	return value, err
 }
@@ -116,3 +116,24 @@ func Unauthorized() (int, interface{}) {
 		"content": http.StatusText(http.StatusUnauthorized),
 	}
 }
+
+// Locked tells that the account or the client is locked for retryAfter seconds.
+func Locked(retryAfter int) (int, interface{}) {
+	return http.StatusLocked, map[string]interface{}{
+		"error":       "Too many failed attempts, try again later.",
+		"code":        http.StatusLocked,
+		"content":     http.StatusText(http.StatusLocked),
+		"retry_after": retryAfter,
+	}
+}
+
+// OAuthError returns an error response in the format of RFC 6749 section 5.2.
+func OAuthError(code int, err string, description string) (int, interface{}) {
+	result := map[string]interface{}{
+		"error": err,
+	}
+	if len(description) > 0 {
+		result["error_description"] = description
+	}
+	return code, result
+}
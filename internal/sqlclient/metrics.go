@@ -0,0 +1,12 @@
+package sqlclient
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// RegisterMetrics registers the sql.DBStats of client, labeled with dbName, with the
+// default Prometheus registry.
+func RegisterMetrics(client ISqlClientConn, dbName string) error {
+	return prometheus.Register(collectors.NewDBStatsCollector(client.GetDB().DB, dbName))
+}
@@ -6,7 +6,11 @@ import (
 	"callcenter-api/common/cache"
 	"callcenter-api/internal/redis"
 	"callcenter-api/internal/sqlclient"
+	"callcenter-api/middleware/auth/goauth"
 	"callcenter-api/repository"
+	"callcenter-api/service"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -16,6 +20,8 @@ import (
 	"time"
 
 	api "callcenter-api/api"
+	apiV1 "callcenter-api/api/v1"
+	authMdw "callcenter-api/middleware/auth"
 
 	_ "time/tzdata"
 
@@ -37,6 +43,9 @@ type Config struct {
 
 var config Config
 
+// appCtx is done once the server stopped, for the background jobs.
+var appCtx, stopApp = context.WithCancel(context.Background())
+
 func init() {
 	loc, err := time.LoadLocation("Asia/Ho_Chi_Minh")
 	if err != nil {
@@ -81,7 +90,13 @@ func init() {
 			MaxOpenConns: 10,
 		}
 		repository.FusionSqlClient = sqlclient.NewSqlClient(sqlClientConfig)
-
+		if err := sqlclient.RegisterMetrics(repository.FusionSqlClient, sqlClientConfig.Database); err != nil {
+			panic(err)
+		}
+		repository.OAuthClientRepo = repository.NewOAuthClient()
+		repository.RoleRepo = repository.NewRole()
+		repository.AuditLogRepo = repository.NewAuditLog()
+		repository.MfaRepo = repository.NewMfa()
 	}
 	if cfg.Redis == "enabled" {
 		var err error
@@ -98,6 +113,110 @@ func init() {
 		if err != nil {
 			panic(err)
 		}
+		if err := redis.RegisterMetrics(redis.Redis.GetClient()); err != nil {
+			panic(err)
+		}
+	}
+	var serviceKeys []authMdw.ServiceKey
+	if err := viper.UnmarshalKey(`service_keys`, &serviceKeys); err != nil {
+		panic(err)
+	}
+	if err := authMdw.SetServiceKeys(serviceKeys); err != nil {
+		panic(err)
+	}
+	authMdw.SetApiKeyScopes(viper.GetStringSlice(`api_key_scopes`))
+	var clientCerts []authMdw.ClientCertIdentity
+	if err := viper.UnmarshalKey(`client_certs`, &clientCerts); err != nil {
+		panic(err)
+	}
+	if err := authMdw.SetClientCertIdentities(clientCerts); err != nil {
+		panic(err)
+	}
+	var passwordConfig authMdw.PasswordConfig
+	if err := viper.UnmarshalKey(`password`, &passwordConfig); err != nil {
+		panic(err)
+	}
+	authMdw.PasswordHasher, err = authMdw.NewPasswordHasher(passwordConfig)
+	if err != nil {
+		panic(err)
+	}
+	if audience := viper.GetString(`jwt.audience`); len(audience) > 0 {
+		goauth.JWTAudience = audience
+	}
+	var tokenStore goauth.TokenStore
+	switch store := viper.GetString(`goauth.store`); store {
+	case "redis":
+		if redis.Redis == nil {
+			panic("goauth redis store requires redis to be enabled")
+		}
+		tokenStore = goauth.NewRedisTokenStore(redis.Redis.GetClient())
+	case "sql":
+		if repository.FusionSqlClient == nil {
+			panic("goauth sql store requires db to be enabled")
+		}
+		tokenStore, err = goauth.NewSqlTokenStore(repository.FusionSqlClient)
+		if err != nil {
+			panic(err)
+		}
+	case "memory":
+		tokenStore = goauth.NewMemoryTokenStore()
+	case "":
+		if redis.Redis != nil {
+			tokenStore = goauth.NewRedisTokenStore(redis.Redis.GetClient())
+		} else {
+			tokenStore = goauth.NewMemoryTokenStore()
+		}
+	default:
+		panic("goauth store " + store + " is not supported")
+	}
+	if redisStore, ok := tokenStore.(*goauth.RedisTokenStore); ok {
+		sweepInterval := viper.GetInt(`goauth.sweep_interval`)
+		if sweepInterval <= 0 {
+			sweepInterval = 300
+		}
+		redisStore.StartSweeper(appCtx, time.Duration(sweepInterval)*time.Second)
+	}
+	var jwtKeys []goauth.SigningKey
+	if err := viper.UnmarshalKey(`jwt.keys`, &jwtKeys); err != nil {
+		panic(err)
+	}
+	if len(jwtKeys) > 0 {
+		goauth.JWTKeys, err = goauth.NewKeySet(jwtKeys, viper.GetString(`jwt.active_kid`))
+	} else if _, ok := tokenStore.(*goauth.MemoryTokenStore); ok || viper.GetBool(`jwt.temporary_key`) {
+		log.Warning("jwt signing key is not configured, generate a temporary key")
+		goauth.JWTKeys, err = goauth.NewTemporaryKeySet()
+	} else {
+		err = errors.New("jwt.keys is required unless goauth uses the memory store or jwt.temporary_key is set")
+	}
+	if err != nil {
+		panic(err)
+	}
+	if err := goauth.RegisterMetrics(tokenStore); err != nil {
+		panic(err)
+	}
+	var clientRegistry goauth.ClientRegistry
+	if repository.OAuthClientRepo != nil {
+		clientRegistry = service.NewOAuthClient()
+	}
+	goauth.GoAuthClient, err = goauth.NewGoAuth(goauth.GoAuth{
+		Store:            tokenStore,
+		RedisExpiredIn:   viper.GetInt(`goauth.expired_in`),
+		RefreshExpiredIn: viper.GetInt(`goauth.refresh_expired_in`),
+		ClientRegistry:   clientRegistry,
+		MaxSessions:      viper.GetInt(`goauth.max_sessions`),
+	})
+	if err != nil {
+		panic(err)
+	}
+	var authConfig authMdw.AuthConfig
+	if err := viper.UnmarshalKey(`auth`, &authConfig); err != nil {
+		panic(err)
+	}
+	authConfig.Mode = cfg.Auth
+	authMdw.SetImpersonation(authConfig.Impersonation)
+	authMdw.AuthMdw, err = authMdw.NewAuthMiddleware(authConfig)
+	if err != nil {
+		panic(fmt.Errorf("invalid auth config: %w", err))
 	}
 	config = cfg
 }
@@ -112,14 +231,104 @@ func main() {
 	setAppLogger(config, file)
 
 	cache.MCache = cache.NewMemCache()
-	defer cache.MCache.Close()
-
 	if redis.Redis != nil {
 		cache.RCache = cache.NewRedisCache(redis.Redis.GetClient())
-		defer cache.RCache.Close()
 	}
-	server := api.NewServer()
-	server.Start(config.Port)
+	if redis.Redis != nil {
+		var lockoutConfig authMdw.LockoutConfig
+		if err := viper.UnmarshalKey(`lockout`, &lockoutConfig); err != nil {
+			panic(err)
+		}
+		authMdw.LoginGuard = authMdw.NewRedisLoginGuard(redis.Redis.GetClient(), lockoutConfig)
+	}
+	var serverConfig api.ServerConfig
+	if err := viper.UnmarshalKey(`server`, &serverConfig); err != nil {
+		panic(err)
+	}
+	server, err := api.NewServer(serverConfig)
+	if err != nil {
+		panic(fmt.Errorf("invalid server config: %w", err))
+	}
+	registerShutdownHooks(server)
+	registerReadinessChecks(server)
+	apiV1.NewAuth(server.Engine)
+	if repository.OAuthClientRepo != nil && (config.Auth == "" || config.Auth == authMdw.AUTH_LOCAL) {
+		apiV1.NewOAuth(server.Engine)
+		apiV1.NewOAuthClient(server.Engine, service.NewOAuthClient())
+		var mfaConfig service.MfaConfig
+		if err := viper.UnmarshalKey(`mfa`, &mfaConfig); err != nil {
+			panic(err)
+		}
+		if mfaConfig.Enabled {
+			mfaService, err := service.NewMfa(mfaConfig)
+			if err != nil {
+				panic(fmt.Errorf("invalid mfa config: %w", err))
+			}
+			authMdw.Mfa = mfaService
+			apiV1.NewMfa(server.Engine, mfaService)
+		}
+	}
+	if repository.RoleRepo != nil {
+		roleService := service.NewRole()
+		authMdw.PermissionResolver = roleService
+		apiV1.NewRole(server.Engine, roleService)
+	}
+	var tlsConfig api.TLSConfig
+	if err := viper.UnmarshalKey(`tls`, &tlsConfig); err != nil {
+		panic(err)
+	}
+	if tlsConfig.Enabled() {
+		serverTLSConfig, err := api.NewTLSConfig(tlsConfig)
+		if err != nil {
+			panic(fmt.Errorf("invalid tls config: %w", err))
+		}
+		server.StartTLS(config.Port, serverTLSConfig)
+	} else {
+		server.Start(config.Port)
+	}
+}
+
+// registerShutdownHooks stops the users of the DB and Redis before closing them.
+func registerShutdownHooks(server *api.Server) {
+	server.OnShutdown("background jobs", func(ctx context.Context) error {
+		stopApp()
+		return nil
+	})
+	server.OnShutdown("audit logs", authMdw.WaitAuditLogs)
+	server.OnShutdown("memory cache", func(ctx context.Context) error {
+		cache.MCache.Close()
+		return nil
+	})
+	if redis.Redis != nil {
+		// RCache shares the client of redis.Redis, closing it ends both.
+		server.OnShutdown("redis", func(ctx context.Context) error {
+			return redis.Redis.GetClient().Close()
+		})
+	}
+	if repository.FusionSqlClient != nil {
+		server.OnShutdown("database", func(ctx context.Context) error {
+			return repository.FusionSqlClient.GetDB().Close()
+		})
+	}
+}
+
+// registerReadinessChecks makes /readyz ping the DB, Redis and, when
+// health.check_auth_url is set, the auth server of the proxy mode.
+func registerReadinessChecks(server *api.Server) {
+	timeout := time.Duration(viper.GetInt(`health.timeout_ms`)) * time.Millisecond
+	if repository.FusionSqlClient != nil {
+		server.AddReadinessCheck("database", timeout, func(ctx context.Context) error {
+			return repository.FusionSqlClient.GetDB().PingContext(ctx)
+		})
+	}
+	if redis.Redis != nil {
+		server.AddReadinessCheck("redis", timeout, func(ctx context.Context) error {
+			return redis.Redis.Ping()
+		})
+	}
+	if viper.GetBool(`health.check_auth_url`) && config.Auth == authMdw.AUTH_PROXY {
+		server.AddReadinessCheck("auth_server", timeout, authMdw.PingAuthServer)
+	}
 }
 
 func setAppLogger(cfg Config, file *os.File) {
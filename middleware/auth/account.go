@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"callcenter-api/common/cache"
+	"callcenter-api/common/log"
+	"callcenter-api/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// EXT_SUBJECT tells what a GoAuthUser stands for, users of v_users have no such extension.
+const (
+	EXT_SUBJECT     = "subject"
+	SUBJECT_CLIENT  = "client"
+	SUBJECT_SERVICE = "service"
+)
+
+var (
+	ErrUserNotFound   = errors.New("user is not found")
+	ErrUserDisabled   = errors.New("user is disabled")
+	ErrDomainDisabled = errors.New("domain is disabled")
+)
+
+const accountStatusTTL = 30 * time.Second
+
+type accountStatus struct {
+	UserEnabled   string `bun:"user_enabled"`
+	DomainEnabled string `bun:"domain_enabled"`
+}
+
+func isEnabled(value string) bool {
+	switch value {
+	case "true", "t", "1":
+		return true
+	default:
+		return false
+	}
+}
+
+// checkUserAuthEnabled refuses a user loaded with its user_enabled and domain_enabled.
+func checkUserAuthEnabled(user *UserAuth) error {
+	if !isEnabled(user.UserEnabled) {
+		return ErrUserDisabled
+	} else if !isEnabled(user.DomainEnabled) {
+		return ErrDomainDisabled
+	}
+	return nil
+}
+
+// CheckAccountEnabled refuses users that were disabled or deleted, or whose domain was
+// disabled, after they authenticated. Clients and service keys are not users and pass.
+// The status is cached for a short time so that revoking access does not wait for
+// the strategy caches.
+func CheckAccountEnabled(ctx context.Context, user *GoAuthUser) error {
+	if len(user.GetExtensions().Get(EXT_SUBJECT)) > 0 {
+		return nil
+	}
+	key := "account_status:" + user.Id
+	if cache.MCache != nil {
+		if value, err := cache.MCache.Get(key); err == nil && value != nil {
+			if status, ok := value.(error); ok {
+				return status
+			} else if value == true {
+				return nil
+			}
+		}
+	}
+	disabledReason, err := getAccountStatus(ctx, user.Id)
+	if err != nil {
+		return err
+	}
+	if cache.MCache != nil {
+		var value interface{} = true
+		if disabledReason != nil {
+			value = disabledReason
+		}
+		if err := cache.MCache.SetTTL(key, value, accountStatusTTL); err != nil {
+			log.Error(err)
+		}
+	}
+	return disabledReason
+}
+
+// getAccountStatus returns in disabledReason why userId may not authenticate, one of
+// ErrUserNotFound, ErrUserDisabled or ErrDomainDisabled, and nil when it may. err is
+// set when the status could not be read.
+func getAccountStatus(ctx context.Context, userId string) (disabledReason error, err error) {
+	if repository.FusionSqlClient == nil {
+		return nil, errors.New("account can not be checked without db")
+	}
+	status := new(accountStatus)
+	err = repository.FusionSqlClient.GetDB().NewSelect().
+		TableExpr("v_users AS u").
+		ColumnExpr("cast(u.user_enabled as text) as user_enabled").
+		ColumnExpr("cast(d.domain_enabled as text) as domain_enabled").
+		Join("inner join v_domains d on u.domain_uuid = d.domain_uuid").
+		Where("u.user_uuid = ?", userId).
+		Scan(ctx, status)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !isEnabled(status.UserEnabled) {
+		return ErrUserDisabled, nil
+	} else if !isEnabled(status.DomainEnabled) {
+		return ErrDomainDisabled, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"callcenter-api/common/log"
+	"callcenter-api/repository"
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/shaj13/go-guardian/v2/auth"
+	"github.com/shaj13/go-guardian/v2/auth/strategies/token"
+)
+
+// API_KEY_HEADER carries api keys, they are never read from the query string where
+// access logs, proxies and browser history would keep them.
+const API_KEY_HEADER = "X-API-Key"
+
+// ServiceKey is a key for another service, only the hex encoded sha256 of the key is
+// configured, for example with: echo -n "$KEY" | sha256sum. A key is granted its
+// Scopes only, which must not be empty.
+type ServiceKey struct {
+	Id         string   `mapstructure:"id"`
+	Name       string   `mapstructure:"name"`
+	KeyHash    string   `mapstructure:"key_hash"`
+	Level      string   `mapstructure:"level"`
+	DomainId   string   `mapstructure:"domain_id"`
+	DomainName string   `mapstructure:"domain_name"`
+	Scopes     []string `mapstructure:"scopes"`
+	hash       []byte
+}
+
+var serviceKeys []ServiceKey
+
+// apiKeyScopes are granted to the user api keys of v_users, none unless configured.
+var apiKeyScopes []string
+
+// SetApiKeyScopes sets the scopes granted to user api keys.
+func SetApiKeyScopes(scopes []string) {
+	apiKeyScopes = scopes
+}
+
+// SetServiceKeys replaces the configured service keys.
+func SetServiceKeys(keys []ServiceKey) error {
+	result := make([]ServiceKey, 0, len(keys))
+	for _, key := range keys {
+		hash, err := hex.DecodeString(key.KeyHash)
+		if err != nil || len(hash) != sha256.Size {
+			return errors.New("service key " + key.Name + " has invalid key_hash")
+		}
+		if len(key.Id) < 1 {
+			return errors.New("service key " + key.Name + " has no id")
+		}
+		if LevelRank(key.Level) < 1 {
+			return errors.New("service key " + key.Name + " has invalid level")
+		}
+		if len(key.Scopes) < 1 {
+			return errors.New("service key " + key.Name + " has no scopes")
+		}
+		key.hash = hash
+		result = append(result, key)
+	}
+	serviceKeys = result
+	return nil
+}
+
+// findServiceKey returns the user of a configured service key, it compares every key
+// in constant time.
+func findServiceKey(key string) *GoAuthUser {
+	if len(key) < 1 {
+		return nil
+	}
+	hash := sha256.Sum256([]byte(key))
+	var found *ServiceKey
+	for i := range serviceKeys {
+		if subtle.ConstantTimeCompare(serviceKeys[i].hash, hash[:]) == 1 {
+			found = &serviceKeys[i]
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	return &GoAuthUser{
+		Id:         found.Id,
+		Name:       found.Name,
+		DomainId:   found.DomainId,
+		DomainName: found.DomainName,
+		Level:      found.Level,
+		Scopes:     found.Scopes,
+		Extensions: auth.Extensions{EXT_SUBJECT: []string{SUBJECT_SERVICE}},
+	}
+}
+
+func newApiKeyStrategy() auth.Strategy {
+	return token.New(validateApiKeyAuth, apiKeyCacheObj, token.SetParser(token.XHeaderParser(API_KEY_HEADER)))
+}
+
+func validateApiKeyAuth(ctx context.Context, r *http.Request, key string) (auth.Info, time.Time, error) {
+	if user := findServiceKey(key); user != nil {
+		return user, time.Now(), nil
+	}
+	user, err := findUserByApiKey(ctx, key)
+	if err != nil {
+		log.Error(err)
+		return nil, time.Time{}, errors.New("invalid credentials")
+	} else if user == nil {
+		return nil, time.Time{}, errors.New("invalid api key")
+	}
+	if err := checkUserAuthEnabled(user); err != nil {
+		log.Errorf("api key refused for user %s: %v", user.UserUuid, err)
+		return nil, time.Time{}, err
+	}
+	return NewGoAuthUser(user.Username, user.UserUuid, nil, nil, user.DomainUuid, user.DomainName, user.Level, apiKeyScopes), time.Now(), nil
+}
+
+// findUserByApiKey looks up the user of key by equality on v_users.api_key. The column
+// stays plaintext because FusionPBX reads and writes it as is for its own API, hashing
+// it here would break the keys FusionPBX issues and checks.
+func findUserByApiKey(ctx context.Context, key string) (*UserAuth, error) {
+	if len(key) < 1 {
+		return nil, nil
+	}
+	user := new(UserAuth)
+	err := repository.FusionSqlClient.GetDB().NewSelect().
+		Model(user).
+		ColumnExpr("u.username, u.user_uuid, u.domain_uuid, u.api_key, u.level").
+		ColumnExpr("cast(u.user_enabled as text) as user_enabled").
+		ColumnExpr("d.domain_name, cast(d.domain_enabled as text) as domain_enabled").
+		Join("inner join v_domains d on u.domain_uuid = d.domain_uuid").
+		Where("u.api_key = ?", key).
+		Limit(1).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
@@ -1,20 +1,17 @@
 package auth
 
 import (
-	"net/http"
-
 	"github.com/gin-gonic/gin"
 	"github.com/shaj13/go-guardian/v2/auth"
 )
 
 const (
-	SECRET_TOKEN = "abc!@#abc123"
-	SUPERADMIN   = "superadmin"
-	ADMIN        = "admin"
-	USER         = "user"
-	LEADER       = "leader"
-	MANAGER      = "manager"
-	AGENT        = "agent"
+	SUPERADMIN = "superadmin"
+	ADMIN      = "admin"
+	USER       = "user"
+	LEADER     = "leader"
+	MANAGER    = "manager"
+	AGENT      = "agent"
 )
 
 type IAuthMiddleware interface {
@@ -23,8 +20,31 @@ type IAuthMiddleware interface {
 
 var AuthMdw IAuthMiddleware
 
+// AuthMiddleware authenticates the request with AuthMdw, switches a superadmin to the
+// tenant it asks for and then resolves the permissions of the user.
 func AuthMiddleware() gin.HandlerFunc {
-	return AuthMdw.AuthMiddleware()
+	authenticate := AuthMdw.AuthMiddleware()
+	return func(c *gin.Context) {
+		authenticate(c)
+		if c.IsAborted() {
+			return
+		}
+		user, ok := GetUser(c)
+		if !ok {
+			return
+		}
+		// the strategies may cache the user, it is changed on a copy only
+		requestUser := *user
+		c.Set("user", &requestUser)
+		if !impersonate(c, &requestUser) {
+			return
+		}
+		resolvePermissions(c)
+		if len(requestUser.OriginalDomainId) > 0 {
+			c.Next()
+			auditImpersonation(c, &requestUser)
+		}
+	}
 }
 
 func GetUser(c *gin.Context) (*GoAuthUser, bool) {
@@ -55,18 +75,26 @@ func GetUserLevel(c *gin.Context) (string, bool) {
 	}
 }
 
+// GetUserDomainId returns the tenant the user acts on, which for a superadmin may be
+// the one of the x-tenant-uuid header.
 func GetUserDomainId(c *gin.Context) (string, bool) {
 	user, ok := GetUser(c)
 	if !ok {
 		return "", false
 	}
-	domainUuid := user.DomainId
-	if user.Level == SUPERADMIN {
-		if tenantUuid := c.GetHeader("x-tenant-uuid"); len(tenantUuid) > 0 {
-			domainUuid = tenantUuid
-		}
+	return user.DomainId, true
+}
+
+// GetUserOriginalDomainId returns the own tenant of the user.
+func GetUserOriginalDomainId(c *gin.Context) (string, bool) {
+	user, ok := GetUser(c)
+	if !ok {
+		return "", false
+	}
+	if len(user.OriginalDomainId) > 0 {
+		return user.OriginalDomainId, true
 	}
-	return domainUuid, true
+	return user.DomainId, true
 }
 
 func GetUserName(c *gin.Context) (string, bool) {
@@ -79,14 +107,19 @@ func GetUserName(c *gin.Context) (string, bool) {
 }
 
 type GoAuthUser struct {
-	DomainId   string          `json:"domain_id"`
-	DomainName string          `json:"domain_name"`
-	Id         string          `json:"id"`
-	Name       string          `json:"name"`
-	Level      string          `json:"level"`
-	Scopes     []string        `json:"scopes"`
-	Extensions auth.Extensions `json:"extensions"`
-	Groups     []string        `json:"groups"`
+	DomainId    string          `json:"domain_id"`
+	DomainName  string          `json:"domain_name"`
+	Id          string          `json:"id"`
+	Name        string          `json:"name"`
+	Level       string          `json:"level"`
+	Scopes      []string        `json:"scopes"`
+	Permissions []string        `json:"permissions,omitempty"`
+	Extensions  auth.Extensions `json:"extensions"`
+	Groups      []string        `json:"groups"`
+	// OriginalDomainId and OriginalDomainName are the own tenant of a superadmin
+	// acting on the tenant in DomainId.
+	OriginalDomainId   string `json:"original_domain_id,omitempty"`
+	OriginalDomainName string `json:"original_domain_name,omitempty"`
 }
 
 func NewGoAuthUser(name, id string, groups []string, extensions auth.Extensions, domainId, domainName, level string, scopes []string) GoAuthInfo {
@@ -169,20 +202,24 @@ func (a *GoAuthUser) GetScopes() []string {
 	return a.Scopes
 }
 
+func (a *GoAuthUser) SetPermissions(permissions []string) {
+	a.Permissions = permissions
+}
+
+func (a *GoAuthUser) GetPermissions() []string {
+	return a.Permissions
+}
+
+// CheckLevelManage allows leaders and the levels above them.
+//
+// Deprecated: use RequireLevel(LEADER).
 func CheckLevelManage() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		level, ok := GetUserLevel(c)
-		if !ok {
-			c.JSON(http.StatusForbidden, map[string]interface{}{
-				"error": http.StatusText(http.StatusUnauthorized),
-			})
-			return
-		}
-		if level != SUPERADMIN && level != ADMIN && level != MANAGER && level != LEADER {
-			c.JSON(http.StatusForbidden, map[string]interface{}{
-				"error": http.StatusText(http.StatusUnauthorized),
-			})
-			return
-		}
-	}
+	return RequireLevel(LEADER)
+}
+
+// CheckLevelSuperAdmin allows superadmins only.
+//
+// Deprecated: use RequireLevel(SUPERADMIN).
+func CheckLevelSuperAdmin() gin.HandlerFunc {
+	return RequireLevel(SUPERADMIN)
 }
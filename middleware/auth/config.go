@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"callcenter-api/middleware/auth/goauth"
+	"callcenter-api/repository"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+const (
+	AUTH_LOCAL   = "local"
+	AUTH_PROXY   = "proxy"
+	AUTH_GATEWAY = "gateway"
+)
+
+// AuthConfig selects the middleware assigned to AuthMdw. Mode comes from main.auth,
+// the settings of each mode from the auth section of the config.
+type AuthConfig struct {
+	Mode    string        `mapstructure:"-"`
+	Proxy   ProxyConfig   `mapstructure:"proxy"`
+	Gateway GatewayConfig `mapstructure:"gateway"`
+	// Impersonation applies to every mode.
+	Impersonation ImpersonationConfig `mapstructure:"impersonation"`
+}
+
+type ProxyConfig struct {
+	AuthUrl string `mapstructure:"auth_url"`
+	// Timeout of one call in milliseconds.
+	Timeout             int    `mapstructure:"timeout"`
+	CaFile              string `mapstructure:"ca_file"`
+	CertFile            string `mapstructure:"cert_file"`
+	KeyFile             string `mapstructure:"key_file"`
+	ServerName          string `mapstructure:"server_name"`
+	InsecureSkipVerify  bool   `mapstructure:"insecure_skip_verify"`
+	MaxIdleConns        int    `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int    `mapstructure:"max_idle_conns_per_host"`
+	// IdleConnTimeout, CacheTTL and BreakerCooldown are in seconds, a negative
+	// CacheTTL disables the cache. A token revoked on the auth server keeps working
+	// here until its cached introspection expires, so CacheTTL bounds how long a
+	// logout takes to apply.
+	IdleConnTimeout  int `mapstructure:"idle_conn_timeout"`
+	CacheTTL         int `mapstructure:"cache_ttl"`
+	Retries          int `mapstructure:"retries"`
+	RetryBackoffMs   int `mapstructure:"retry_backoff_ms"`
+	BreakerThreshold int `mapstructure:"breaker_threshold"`
+	BreakerCooldown  int `mapstructure:"breaker_cooldown"`
+}
+
+type GatewayConfig struct {
+	Headers GatewayHeaders `mapstructure:"headers"`
+	// TrustedProxies are the networks, in CIDR notation, allowed to send the headers.
+	// Any caller is trusted when empty.
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+	// Secret is shared with the gateway to sign the headers with HMAC-SHA256.
+	Secret string `mapstructure:"secret"`
+	// MaxSkew is how far, in seconds, the signed timestamp may be from now.
+	MaxSkew int `mapstructure:"max_skew"`
+	// JWT, when it has keys, replaces the headers with a token signed by the gateway.
+	JWT GatewayJWTConfig `mapstructure:"jwt"`
+	// AllowUnsigned trusts headers without any signature, only for a gateway that is
+	// the sole way to reach the service.
+	AllowUnsigned bool `mapstructure:"allow_unsigned"`
+}
+
+type GatewayJWTConfig struct {
+	Header   string              `mapstructure:"header"`
+	Issuer   string              `mapstructure:"issuer"`
+	Audience string              `mapstructure:"audience"`
+	Keys     []goauth.SigningKey `mapstructure:"keys"`
+}
+
+// GatewayHeaders names the headers that carry the user, empty names keep the defaults.
+type GatewayHeaders struct {
+	UserId     string `mapstructure:"user_id"`
+	UserName   string `mapstructure:"user_name"`
+	UserLevel  string `mapstructure:"user_level"`
+	TenantId   string `mapstructure:"tenant_id"`
+	TenantName string `mapstructure:"tenant_name"`
+	Scopes     string `mapstructure:"scopes"`
+	Timestamp  string `mapstructure:"timestamp"`
+	Signature  string `mapstructure:"signature"`
+}
+
+// NewAuthMiddleware returns the middleware of config.Mode, local when it is empty.
+func NewAuthMiddleware(config AuthConfig) (IAuthMiddleware, error) {
+	switch config.Mode {
+	case "", AUTH_LOCAL:
+		if repository.FusionSqlClient == nil {
+			return nil, errors.New("auth mode local needs main.db enabled")
+		}
+		return NewLocalAuthMiddleware(), nil
+	case AUTH_PROXY:
+		if len(config.Proxy.AuthUrl) < 1 {
+			return nil, errors.New("auth mode proxy needs auth.proxy.auth_url")
+		}
+		authUrl, err := url.Parse(config.Proxy.AuthUrl)
+		if err != nil || (authUrl.Scheme != "http" && authUrl.Scheme != "https") || len(authUrl.Host) < 1 {
+			return nil, fmt.Errorf("auth.proxy.auth_url %q is not a valid http url", config.Proxy.AuthUrl)
+		}
+		return NewGoAuthMiddleware(config.Proxy)
+	case AUTH_GATEWAY:
+		return NewGatewayAuthMiddleware(config.Gateway)
+	default:
+		return nil, fmt.Errorf("auth mode %q is not supported, use %s, %s or %s", config.Mode, AUTH_LOCAL, AUTH_PROXY, AUTH_GATEWAY)
+	}
+}
+
+func parseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q is not a valid CIDR", cidr)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
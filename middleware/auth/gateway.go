@@ -2,24 +2,124 @@ package auth
 
 import (
 	"callcenter-api/common/log"
+	"callcenter-api/middleware/auth/goauth"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
+var defaultGatewayHeaders = GatewayHeaders{
+	UserId:     "X-User-Id",
+	UserName:   "X-User-Name",
+	UserLevel:  "X-User-Level",
+	TenantId:   "X-Tenant-Id",
+	TenantName: "X-Tenant-Name",
+	Scopes:     "X-User-Scopes",
+	Timestamp:  "X-Gateway-Timestamp",
+	Signature:  "X-Gateway-Signature",
+}
+
+const (
+	defaultGatewayJWTHeader = "X-Gateway-Token"
+	defaultGatewayMaxSkew   = 60
+)
+
 type GatewayAuthMiddleware struct {
+	headers        GatewayHeaders
+	trustedProxies []*net.IPNet
+	secret         []byte
+	maxSkew        time.Duration
+	jwtHeader      string
+	jwtIssuer      string
+	jwtAudience    string
+	jwtKeys        *goauth.KeySet
+	allowUnsigned  bool
 }
 
-func NewGatewayAuthMiddleware() IAuthMiddleware {
-	return &GatewayAuthMiddleware{}
+// NewGatewayAuthMiddleware trusts the user sent by a gateway. The gateway either signs
+// the headers, sends a JWT, or is explicitly trusted with config.AllowUnsigned.
+func NewGatewayAuthMiddleware(config GatewayConfig) (IAuthMiddleware, error) {
+	trustedProxies, err := parseNetworks(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	headers := config.Headers
+	for _, header := range []struct {
+		value        *string
+		defaultValue string
+	}{
+		{&headers.UserId, defaultGatewayHeaders.UserId},
+		{&headers.UserName, defaultGatewayHeaders.UserName},
+		{&headers.UserLevel, defaultGatewayHeaders.UserLevel},
+		{&headers.TenantId, defaultGatewayHeaders.TenantId},
+		{&headers.TenantName, defaultGatewayHeaders.TenantName},
+		{&headers.Scopes, defaultGatewayHeaders.Scopes},
+		{&headers.Timestamp, defaultGatewayHeaders.Timestamp},
+		{&headers.Signature, defaultGatewayHeaders.Signature},
+	} {
+		if len(*header.value) < 1 {
+			*header.value = header.defaultValue
+		}
+	}
+	mdw := &GatewayAuthMiddleware{
+		headers:        headers,
+		trustedProxies: trustedProxies,
+		secret:         []byte(config.Secret),
+		maxSkew:        time.Duration(config.MaxSkew) * time.Second,
+		jwtHeader:      config.JWT.Header,
+		jwtIssuer:      config.JWT.Issuer,
+		jwtAudience:    config.JWT.Audience,
+		allowUnsigned:  config.AllowUnsigned,
+	}
+	if mdw.maxSkew <= 0 {
+		mdw.maxSkew = defaultGatewayMaxSkew * time.Second
+	}
+	if len(mdw.jwtHeader) < 1 {
+		mdw.jwtHeader = defaultGatewayJWTHeader
+	}
+	if len(config.JWT.Keys) > 0 {
+		mdw.jwtKeys, err = goauth.NewVerifyKeySet(config.JWT.Keys)
+		if err != nil {
+			return nil, fmt.Errorf("auth.gateway.jwt: %w", err)
+		}
+	} else if len(mdw.secret) < 1 && !mdw.allowUnsigned {
+		return nil, errors.New("auth mode gateway needs auth.gateway.secret or auth.gateway.jwt.keys")
+	}
+	if mdw.jwtKeys == nil && len(mdw.secret) < 1 {
+		log.Warning("gateway headers are not signed, anyone reaching the service can choose its user")
+	}
+	return mdw, nil
 }
 
 func (mdw *GatewayAuthMiddleware) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		user := ParseHeaderToUser(c)
-		if len(user.GetID()) < 1 {
-			log.Error("invalid credentials")
+		if !mdw.isTrusted(c) {
+			log.Errorf("gateway headers from untrusted address %s", c.RemoteIP())
+			c.JSON(
+				http.StatusUnauthorized,
+				map[string]interface{}{
+					"error": http.StatusText(http.StatusUnauthorized),
+				},
+			)
+			c.Abort()
+			return
+		}
+		user, err := mdw.authenticate(c)
+		if err == nil && len(user.GetID()) < 1 {
+			err = errors.New("user id is missing")
+		}
+		if err != nil {
+			log.Error("invalid credentials: ", err)
 			c.JSON(
 				http.StatusUnauthorized,
 				map[string]interface{}{
@@ -33,12 +133,134 @@ func (mdw *GatewayAuthMiddleware) AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+func (mdw *GatewayAuthMiddleware) authenticate(c *gin.Context) (*GoAuthUser, error) {
+	if mdw.jwtKeys != nil {
+		return mdw.parseJWT(c.Request.Header.Get(mdw.jwtHeader))
+	}
+	if len(mdw.secret) > 0 {
+		if err := mdw.verifySignature(c); err != nil {
+			return nil, err
+		}
+	}
+	return parseHeaderToUser(c, mdw.headers), nil
+}
+
+// GatewaySignature returns the hex encoded HMAC-SHA256 that the gateway sends for a
+// request. The signed message is the timestamp, the method, the path, the raw query
+// and the values of the user id, user name, user level, tenant id, tenant name, scopes
+// and x-tenant-uuid headers, each followed by a new line.
+func GatewaySignature(secret []byte, timestamp, method, path, rawQuery string, values ...string) string {
+	mac := hmac.New(sha256.New, secret)
+	for _, value := range append([]string{timestamp, method, path, rawQuery}, values...) {
+		mac.Write([]byte(value))
+		mac.Write([]byte("\n"))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (mdw *GatewayAuthMiddleware) verifySignature(c *gin.Context) error {
+	header := c.Request.Header
+	timestamp := header.Get(mdw.headers.Timestamp)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("gateway timestamp is invalid")
+	}
+	skew := time.Since(time.Unix(seconds, 0))
+	if skew > mdw.maxSkew || skew < -mdw.maxSkew {
+		return fmt.Errorf("gateway timestamp is %s away", skew.Round(time.Second))
+	}
+	signature, err := hex.DecodeString(header.Get(mdw.headers.Signature))
+	if err != nil {
+		return errors.New("gateway signature is invalid")
+	}
+	expected := GatewaySignature(mdw.secret, timestamp, c.Request.Method, c.Request.URL.Path, c.Request.URL.RawQuery,
+		header.Get(mdw.headers.UserId),
+		header.Get(mdw.headers.UserName),
+		header.Get(mdw.headers.UserLevel),
+		header.Get(mdw.headers.TenantId),
+		header.Get(mdw.headers.TenantName),
+		header.Get(mdw.headers.Scopes),
+		header.Get(TENANT_HEADER),
+	)
+	expectedBytes, _ := hex.DecodeString(expected)
+	if !hmac.Equal(signature, expectedBytes) {
+		return errors.New("gateway signature does not match")
+	}
+	return nil
+}
+
+// parseJWT reads the user from a gateway token with the claims sub, username, level,
+// domain_uuid, domain_name and scope. The token must expire.
+func (mdw *GatewayAuthMiddleware) parseJWT(tokenString string) (*GoAuthUser, error) {
+	tokenString = strings.TrimSpace(strings.TrimPrefix(tokenString, "Bearer "))
+	if len(tokenString) < 1 {
+		return nil, errors.New("gateway token is missing")
+	}
+	claims, err := mdw.jwtKeys.Parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errors.New("gateway token has no exp")
+	}
+	if len(mdw.jwtIssuer) > 0 && !claims.VerifyIssuer(mdw.jwtIssuer, true) {
+		return nil, errors.New("gateway token issuer is invalid")
+	}
+	if len(mdw.jwtAudience) > 0 && !claims.VerifyAudience(mdw.jwtAudience, true) {
+		return nil, errors.New("gateway token audience is invalid")
+	}
+	id, _ := claims["sub"].(string)
+	name, _ := claims["username"].(string)
+	level, _ := claims["level"].(string)
+	domainId, _ := claims["domain_uuid"].(string)
+	domainName, _ := claims["domain_name"].(string)
+	scope, _ := claims["scope"].(string)
+	scopes := goauth.ParseScope(scope)
+	if len(scopes) < 1 {
+		scopes = []string{goauth.SCOPE_ALL}
+	}
+	return &GoAuthUser{
+		Id:         id,
+		Name:       name,
+		Level:      level,
+		DomainId:   domainId,
+		DomainName: domainName,
+		Scopes:     scopes,
+	}, nil
+}
+
+func (mdw *GatewayAuthMiddleware) isTrusted(c *gin.Context) bool {
+	if len(mdw.trustedProxies) < 1 {
+		return true
+	}
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil {
+		return false
+	}
+	for _, network := range mdw.trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseHeaderToUser reads the user from the default gateway headers.
 func ParseHeaderToUser(c *gin.Context) *GoAuthUser {
+	return parseHeaderToUser(c, defaultGatewayHeaders)
+}
+
+func parseHeaderToUser(c *gin.Context, headers GatewayHeaders) *GoAuthUser {
+	scopes := goauth.ParseScope(c.Request.Header.Get(headers.Scopes))
+	if len(scopes) < 1 {
+		scopes = []string{goauth.SCOPE_ALL}
+	}
 	return &GoAuthUser{
-		DomainId:   c.Request.Header.Get("X-Tenant-Id"),
-		DomainName: c.Request.Header.Get("X-Tenant-Name"),
-		Id:         c.Request.Header.Get("X-User-Id"),
-		Level:      c.Request.Header.Get("X-User-Level"),
-		Name:       c.Request.Header.Get("X-User-Name"),
+		DomainId:   c.Request.Header.Get(headers.TenantId),
+		DomainName: c.Request.Header.Get(headers.TenantName),
+		Id:         c.Request.Header.Get(headers.UserId),
+		Level:      c.Request.Header.Get(headers.UserLevel),
+		Name:       c.Request.Header.Get(headers.UserName),
+		Scopes:     scopes,
 	}
 }
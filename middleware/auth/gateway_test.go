@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newSignedGatewayRequest(secret []byte, timestamp time.Time, target string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	headers := defaultGatewayHeaders
+	r.Header.Set(headers.UserId, "user")
+	r.Header.Set(headers.UserName, "name")
+	r.Header.Set(headers.UserLevel, AGENT)
+	r.Header.Set(headers.TenantId, "tenant")
+	r.Header.Set(headers.TenantName, "tenant.example.com")
+	r.Header.Set(headers.Scopes, "cdr:read")
+	seconds := strconv.FormatInt(timestamp.Unix(), 10)
+	r.Header.Set(headers.Timestamp, seconds)
+	r.Header.Set(headers.Signature, GatewaySignature(secret, seconds, r.Method, r.URL.Path, r.URL.RawQuery,
+		"user", "name", AGENT, "tenant", "tenant.example.com", "cdr:read", ""))
+	return r
+}
+
+func TestVerifyGatewaySignature(t *testing.T) {
+	secret := []byte("secret")
+	mdw := &GatewayAuthMiddleware{headers: defaultGatewayHeaders, secret: secret, maxSkew: time.Minute}
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		wantErr bool
+	}{
+		{"signed", func() *http.Request {
+			return newSignedGatewayRequest(secret, time.Now(), "/v1/cdr?page=1")
+		}, false},
+		{"other secret", func() *http.Request {
+			return newSignedGatewayRequest([]byte("other"), time.Now(), "/v1/cdr")
+		}, true},
+		{"timestamp too old", func() *http.Request {
+			return newSignedGatewayRequest(secret, time.Now().Add(-2*time.Minute), "/v1/cdr")
+		}, true},
+		{"timestamp too far ahead", func() *http.Request {
+			return newSignedGatewayRequest(secret, time.Now().Add(2*time.Minute), "/v1/cdr")
+		}, true},
+		{"missing timestamp", func() *http.Request {
+			r := newSignedGatewayRequest(secret, time.Now(), "/v1/cdr")
+			r.Header.Del(defaultGatewayHeaders.Timestamp)
+			return r
+		}, true},
+		{"signature not hex", func() *http.Request {
+			r := newSignedGatewayRequest(secret, time.Now(), "/v1/cdr")
+			r.Header.Set(defaultGatewayHeaders.Signature, "not hex")
+			return r
+		}, true},
+		{"changed user", func() *http.Request {
+			r := newSignedGatewayRequest(secret, time.Now(), "/v1/cdr")
+			r.Header.Set(defaultGatewayHeaders.UserLevel, SUPERADMIN)
+			return r
+		}, true},
+		{"changed path", func() *http.Request {
+			r := newSignedGatewayRequest(secret, time.Now(), "/v1/cdr")
+			r.URL.Path = "/v1/users"
+			return r
+		}, true},
+		{"changed query", func() *http.Request {
+			r := newSignedGatewayRequest(secret, time.Now(), "/v1/cdr?page=1")
+			r.URL.RawQuery = "page=2"
+			return r
+		}, true},
+		{"added tenant", func() *http.Request {
+			r := newSignedGatewayRequest(secret, time.Now(), "/v1/cdr")
+			r.Header.Set(TENANT_HEADER, "other-tenant")
+			return r
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = tt.request()
+			if err := mdw.verifySignature(c); (err != nil) != tt.wantErr {
+				t.Errorf("verifySignature error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	GRANT_CLIENT_CREDENTIALS = "client_credentials"
+	GRANT_PASSWORD           = "password"
+	GRANT_REFRESH_TOKEN      = "refresh_token"
+	// GRANT_MFA_OTP completes a password grant that needs a second factor, clients
+	// that may use the password grant may use it.
+	GRANT_MFA_OTP = "mfa_otp"
+)
+
+var (
+	ErrInvalidClient      = errors.New("client authentication failed")
+	ErrUnauthorizedClient = errors.New("client is not allowed to use this grant type")
+	ErrInvalidScope       = errors.New("requested scope is not allowed for client")
+)
+
+// RegisteredClient is an OAuth client known to a ClientRegistry. A client without
+// SecretHash is a public client and can not use the client_credentials grant.
+type RegisteredClient struct {
+	ClientId         string
+	SecretHash       string
+	GrantTypes       []string
+	Scopes           []string
+	ExpiredIn        int
+	RefreshExpiredIn int
+	Enabled          bool
+}
+
+// ClientRegistry looks up the OAuth clients allowed to request tokens. It returns
+// nil when the client does not exist.
+type ClientRegistry interface {
+	GetClient(ctx context.Context, clientId string) (*RegisteredClient, error)
+}
+
+func (c *RegisteredClient) allowGrantType(grantType string) bool {
+	for _, value := range c.GrantTypes {
+		if value == grantType {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *RegisteredClient) allowScope(scope string) bool {
+	return HasScope(c.Scopes, scope)
+}
+
+// AuthenticateClient checks the credentials of clientId against the registry.
+// A public client authenticates with its id only.
+func (g *GoAuth) AuthenticateClient(ctx context.Context, clientId, clientSecret string) (*RegisteredClient, error) {
+	if g.ClientRegistry == nil {
+		return nil, errors.New("client registry is not configured")
+	}
+	if clientId == "" {
+		return nil, ErrInvalidClient
+	}
+	client, err := g.ClientRegistry.GetClient(ctx, clientId)
+	if err != nil {
+		return nil, err
+	} else if client == nil || !client.Enabled {
+		return nil, ErrInvalidClient
+	}
+	if client.SecretHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(clientSecret)); err != nil {
+			return nil, ErrInvalidClient
+		}
+	}
+	return client, nil
+}
+
+// AuthorizeGrant authenticates clientId and checks that it may use grantType, before
+// any credential of a user is looked at.
+func (g *GoAuth) AuthorizeGrant(ctx context.Context, clientId, clientSecret, grantType string) (*RegisteredClient, error) {
+	registered, err := g.AuthenticateClient(ctx, clientId, clientSecret)
+	if err != nil {
+		return nil, err
+	}
+	if !registered.allowGrantType(grantType) {
+		return nil, ErrUnauthorizedClient
+	} else if grantType == GRANT_CLIENT_CREDENTIALS && registered.SecretHash == "" {
+		return nil, ErrUnauthorizedClient
+	}
+	return registered, nil
+}
+
+// verifyClient checks a token request against the registry and applies the scopes
+// and token lifetimes of the registered client.
+func (g *GoAuth) verifyClient(ctx context.Context, client AuthClient) (AuthClient, error) {
+	registered, err := g.AuthorizeGrant(ctx, client.ClienId, client.ClientSecret, client.GrantType)
+	if err != nil {
+		return client, err
+	}
+	if len(client.Scopes) == 0 {
+		client.Scopes = registered.Scopes
+	}
+	for _, scope := range client.Scopes {
+		if !registered.allowScope(scope) {
+			return client, ErrInvalidScope
+		}
+	}
+	if client.ExpiredIn == 0 {
+		client.ExpiredIn = registered.ExpiredIn
+	}
+	if client.RefreshExpiredIn == 0 {
+		client.RefreshExpiredIn = registered.RefreshExpiredIn
+	}
+	client.Registered = true
+	return client, nil
+}
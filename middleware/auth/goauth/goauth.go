@@ -3,7 +3,6 @@ package goauth
 import (
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"errors"
 	"strings"
 	"time"
@@ -15,28 +14,59 @@ import (
 )
 
 const (
-	tokenKey  = "access_token_key"
-	userKey   = "access_user_key"
-	expiredIn = 10000
-	redisHost = "localhost"
-	redisPort = "6379"
-	redisDb   = 2
-	tokenType = "Bearer"
+	tokenKey         = "access_token_key"
+	userKey          = "access_user_key"
+	refreshKey       = "refresh_token_key"
+	refreshUsedKey   = "refresh_used_key"
+	revokedKey       = "revoked_token"
+	expiredIn        = 10000
+	refreshExpiredIn = 2592000
+	redisHost        = "localhost"
+	redisPort        = "6379"
+	redisDb          = 2
+	tokenType        = "Bearer"
 )
 
 var GoAuthClient IGoAuth
 
+var (
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid")
+	ErrRefreshTokenExpired = errors.New("refresh token is expired")
+	ErrRefreshTokenUsed    = errors.New("refresh token is already used")
+)
+
 type IGoAuth interface {
 	ClientCredential(ctx context.Context, client AuthClient, isRefresh bool) (AuthClient, error)
 	CheckTokenInRedis(ctx context.Context, token string) (AuthClient, error)
+	RefreshToken(ctx context.Context, clientId, refreshToken string) (AuthClient, error)
+	Revoke(ctx context.Context, token string) error
+	RevokeAllForUser(ctx context.Context, userId string) error
+	IsRevoked(ctx context.Context, token string) (bool, error)
+	AuthenticateClient(ctx context.Context, clientId, clientSecret string) (*RegisteredClient, error)
+	AuthorizeGrant(ctx context.Context, clientId, clientSecret, grantType string) (*RegisteredClient, error)
+	ListSessions(ctx context.Context, userId string) ([]Session, error)
+	RevokeSession(ctx context.Context, userId, sessionId string) error
 }
 
 type GoAuth struct {
-	RedisTokenKey  string
-	RedisUserKey   string
-	RedisExpiredIn int
-	RedisClient    *redis.Client
-	TokenType      string
+	RedisTokenKey       string
+	RedisUserKey        string
+	RedisRefreshKey     string
+	RedisRefreshUsedKey string
+	RedisRevokedKey     string
+	RedisExpiredIn      int
+	RefreshExpiredIn    int
+	RedisClient         *redis.Client
+	// Store persists the issued tokens. When it is nil, a RedisTokenStore is built
+	// from RedisClient and the Redis* keys.
+	Store     TokenStore
+	TokenType string
+	// ClientRegistry, when set, is checked by ClientCredential and RefreshToken before
+	// a token is issued.
+	ClientRegistry ClientRegistry
+	// MaxSessions limits the sessions of one user, the oldest sessions are ended to
+	// make room for a new one. Zero means no limit.
+	MaxSessions int
 }
 
 type AuthClient struct {
@@ -51,6 +81,33 @@ type AuthClient struct {
 	TokenType    string                 `json:"token_type"`
 	JWT          string                 `json:"jwt"`
 	UserData     map[string]interface{} `json:"-"`
+	// RefreshExpiredTime is the time after which RefreshToken can no longer be exchanged.
+	RefreshExpiredTime time.Time `json:"refresh_expired_at"`
+	RefreshExpiredIn   int       `json:"refresh_expired_in,omitempty"`
+	GrantType          string    `json:"grant_type,omitempty"`
+	ClientSecret       string    `json:"-"`
+	// SessionId tells apart the sessions of one user and client, one per login or
+	// per DeviceId when the client sends one.
+	SessionId string `json:"session_id,omitempty"`
+	DeviceId  string `json:"device_id,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	IpAddress string `json:"ip_address,omitempty"`
+	// Registered is set on tokens of a client of the ClientRegistry, whose Scopes are
+	// exactly what it was granted, none when empty.
+	Registered bool `json:"registered,omitempty"`
+}
+
+// SessionKey identifies the stored session of a client. A client acting on behalf of
+// a user, as in the password grant, keeps its sessions per user.
+func (c AuthClient) SessionKey() string {
+	key := c.ClienId
+	if c.UserId != "" && c.UserId != c.ClienId {
+		key += ":" + c.UserId
+	}
+	if c.SessionId != "" {
+		key += ":" + c.SessionId
+	}
+	return key
 }
 
 func NewGoAuth(client GoAuth) (IGoAuth, error) {
@@ -65,21 +122,52 @@ func NewGoAuth(client GoAuth) (IGoAuth, error) {
 	} else {
 		g.RedisUserKey = client.RedisUserKey
 	}
+	if client.RedisRefreshKey == "" {
+		g.RedisRefreshKey = refreshKey
+	} else {
+		g.RedisRefreshKey = client.RedisRefreshKey
+	}
+	if client.RedisRefreshUsedKey == "" {
+		g.RedisRefreshUsedKey = refreshUsedKey
+	} else {
+		g.RedisRefreshUsedKey = client.RedisRefreshUsedKey
+	}
+	if client.RedisRevokedKey == "" {
+		g.RedisRevokedKey = revokedKey
+	} else {
+		g.RedisRevokedKey = client.RedisRevokedKey
+	}
 	if client.RedisExpiredIn == 0 {
 		g.RedisExpiredIn = expiredIn
 	} else {
 		g.RedisExpiredIn = client.RedisExpiredIn
 	}
-	if client.RedisClient == nil {
-		return nil, errors.New("please config redis client")
+	if client.RefreshExpiredIn == 0 {
+		g.RefreshExpiredIn = refreshExpiredIn
 	} else {
+		g.RefreshExpiredIn = client.RefreshExpiredIn
+	}
+	if client.Store != nil {
+		g.Store = client.Store
+	} else if client.RedisClient != nil {
 		g.RedisClient = client.RedisClient
+		store := NewRedisTokenStore(g.RedisClient)
+		store.TokenKey = g.RedisTokenKey
+		store.UserKey = g.RedisUserKey
+		store.RefreshKey = g.RedisRefreshKey
+		store.RefreshUsedKey = g.RedisRefreshUsedKey
+		store.RevokedKey = g.RedisRevokedKey
+		g.Store = store
+	} else {
+		return nil, errors.New("please config token store or redis client")
 	}
 	if client.TokenType == "" {
 		g.TokenType = tokenType
 	} else {
 		g.TokenType = client.TokenType
 	}
+	g.ClientRegistry = client.ClientRegistry
+	g.MaxSessions = client.MaxSessions
 	return g, nil
 }
 
@@ -92,83 +180,37 @@ func GenerateJWT(id string, data map[string]interface{}) string {
 			claim[key] = value
 		}
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-	jwtToken, _ := token.SignedString([]byte("secret"))
-	return jwtToken
-}
-
-func (g *GoAuth) getTokenFromRedis(ctx context.Context, clientId string) (interface{}, error) {
-	res, err := g.RedisClient.HMGet(ctx, g.RedisTokenKey, clientId).Result()
-	if err != nil {
-		return nil, err
-	}
-	var authClient AuthClient
-	if len(res) == 0 {
-		return nil, nil
-	} else {
-		authClientRes, ok := res[0].(string)
-		if ok {
-			err := json.Unmarshal([]byte(authClientRes), &authClient)
-			if err != nil {
-				log.Error(err)
-				return nil, err
-			}
-		}
-		return authClient, nil
+	if JWTKeys == nil {
+		log.Error("jwt keys are not configured")
+		return ""
 	}
-}
-
-func (g *GoAuth) getUserFromRedis(ctx context.Context, clientId string) (interface{}, error) {
-	res, err := g.RedisClient.HMGet(ctx, g.RedisUserKey, clientId).Result()
+	jwtToken, err := JWTKeys.Sign(claim)
 	if err != nil {
-		return nil, err
-	}
-	var authClient AuthClient
-	if len(res) == 0 {
-		return nil, nil
-	} else {
-		authClientRes, ok := res[0].(string)
-		if ok {
-			err := json.Unmarshal([]byte(authClientRes), &authClient)
-			if err != nil {
-				return nil, err
-			}
-		}
-		return authClient, nil
+		log.Error(err)
+		return ""
 	}
+	return jwtToken
 }
 
-func (g *GoAuth) insertClientToRedis(ctx context.Context, client AuthClient) error {
-	clientId := client.ClienId
-	token := client.Token
-	jsonClient, err := json.Marshal(client)
-	if err != nil {
-		return err
-	}
-	jsonClientString := string(jsonClient)
-	clientStoreInfo := map[string]interface{}{clientId: jsonClientString}
-	tokenStoreInfo := map[string]interface{}{token: jsonClientString}
-	if err := g.RedisClient.HSet(ctx, g.RedisUserKey, clientStoreInfo).Err(); err != nil {
-		return err
-	}
-	if err := g.RedisClient.HSet(ctx, g.RedisTokenKey, tokenStoreInfo).Err(); err != nil {
-		return err
+// parseJWTData returns the claims stored in a JWT issued by GenerateJWT,
+// without the "id" claim, so that they can be carried over to a new token.
+func parseJWTData(jwtToken string) map[string]interface{} {
+	data := make(map[string]interface{})
+	if jwtToken == "" {
+		return data
 	}
-	return nil
-}
-
-func (g *GoAuth) deleteClientFromRedis(ctx context.Context, client AuthClient) error {
-	clientId := client.ClienId
-	token := client.Token
-	err := g.RedisClient.HDel(ctx, g.RedisUserKey, clientId).Err()
-	if err != nil {
-		return err
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(jwtToken, claims); err != nil {
+		log.Error(err)
+		return data
 	}
-	err = g.RedisClient.HDel(ctx, g.RedisTokenKey, token).Err()
-	if err != nil {
-		return err
+	for key, value := range claims {
+		if key == "id" {
+			continue
+		}
+		data[key] = value
 	}
-	return err
+	return data
 }
 
 func (g *GoAuth) mapClientData(client AuthClient) AuthClient {
@@ -180,22 +222,53 @@ func (g *GoAuth) mapClientData(client AuthClient) AuthClient {
 		expiredIn = g.RedisExpiredIn
 	}
 	expiredTime := currentTime.Add(time.Duration(expiredIn) * time.Second)
+	refreshExpiredIn := g.RefreshExpiredIn
+	if client.RefreshExpiredIn != 0 {
+		refreshExpiredIn = client.RefreshExpiredIn
+	}
 	accesstoken := AuthClient{
-		ClienId:      client.ClienId,
-		UserId:       client.UserId,
-		Token:        GenerateToken(client.ClienId),
-		RefreshToken: GenerateRefreshToken(client.ClienId),
-		CreatedTime:  currentTime,
-		ExpiredTime:  expiredTime,
-		Scopes:       client.Scopes,
-		TokenType:    g.TokenType,
-	}
-	accesstoken.JWT = GenerateJWT(client.UserId, client.UserData)
+		ClienId:            client.ClienId,
+		UserId:             client.UserId,
+		Token:              GenerateToken(client.ClienId),
+		RefreshToken:       GenerateRefreshToken(client.ClienId),
+		CreatedTime:        currentTime,
+		ExpiredTime:        expiredTime,
+		ExpiredIn:          client.ExpiredIn,
+		Scopes:             client.Scopes,
+		TokenType:          g.TokenType,
+		RefreshExpiredTime: currentTime.Add(time.Duration(refreshExpiredIn) * time.Second),
+		RefreshExpiredIn:   client.RefreshExpiredIn,
+		GrantType:          client.GrantType,
+		SessionId:          client.SessionId,
+		DeviceId:           client.DeviceId,
+		UserAgent:          client.UserAgent,
+		IpAddress:          client.IpAddress,
+		Registered:         client.Registered,
+	}
+	jwtData := make(map[string]interface{})
+	for key, value := range client.UserData {
+		jwtData[key] = value
+	}
+	if len(client.Scopes) > 0 {
+		jwtData["scope"] = strings.Join(client.Scopes, " ")
+	}
+	jwtData["typ"] = JWT_TYPE_ACCESS
+	jwtData["aud"] = JWTAudience
+	jwtData["iat"] = currentTime.Unix()
+	jwtData["exp"] = expiredTime.Unix()
+	accesstoken.JWT = GenerateJWT(client.UserId, jwtData)
 	return accesstoken
 }
 
 func (g *GoAuth) ClientCredential(ctx context.Context, client AuthClient, isRefresh bool) (AuthClient, error) {
-	client, err := g.checkClientInRedis(ctx, client)
+	if g.ClientRegistry != nil {
+		var err error
+		client, err = g.verifyClient(ctx, client)
+		if err != nil {
+			return client, err
+		}
+	}
+	client, err := g.checkClientInStore(ctx, client)
 	if err != nil {
 		return client, err
 	}
@@ -205,32 +278,30 @@ func (g *GoAuth) ClientCredential(ctx context.Context, client AuthClient, isRefr
 	}
 	return clientResponse, nil
 }
-func (g *GoAuth) checkClientInRedis(ctx context.Context, client AuthClient) (AuthClient, error) {
-	var clientNew AuthClient
-	clientRes, err := g.getUserFromRedis(ctx, client.ClienId)
+func (g *GoAuth) checkClientInStore(ctx context.Context, client AuthClient) (AuthClient, error) {
+	if client.SessionId == "" && client.UserId != "" && client.UserId != client.ClienId {
+		client.SessionId = newSessionId(client.DeviceId)
+	}
+	clientNew, err := g.Store.GetBySession(ctx, client.SessionKey())
 	if err != nil {
 		return clientNew, err
 	}
-	if clientRes != "" {
-		var ok bool
-		clientNew, ok = clientRes.(AuthClient)
-		if !ok {
-			return clientNew, errors.New("parse client json failed")
-		}
-	}
 	if clientNew.ClienId == "" {
+		if err := g.enforceSessionLimit(ctx, client.UserId); err != nil {
+			return clientNew, err
+		}
 		clientNew = g.mapClientData(client)
-		if err := g.insertClientToRedis(ctx, clientNew); err != nil {
+		if err := g.Store.Save(ctx, clientNew); err != nil {
 			return clientNew, err
 		}
 	} else {
 		currentTime := time.Now().Local()
 		if clientNew.ExpiredTime.Sub(currentTime) <= 0 {
-			if err := g.deleteClientFromRedis(ctx, clientNew); err != nil {
+			if err := g.Store.Delete(ctx, clientNew); err != nil {
 				return clientNew, err
 			}
 			clientNew = g.mapClientData(client)
-			if err := g.insertClientToRedis(ctx, clientNew); err != nil {
+			if err := g.Store.Save(ctx, clientNew); err != nil {
 				return clientNew, err
 			}
 		} else {
@@ -241,15 +312,10 @@ func (g *GoAuth) checkClientInRedis(ctx context.Context, client AuthClient) (Aut
 }
 
 func (g *GoAuth) CheckTokenInRedis(ctx context.Context, token string) (AuthClient, error) {
-	var client AuthClient
-	clientRes, err := g.getTokenFromRedis(ctx, token)
+	client, err := g.Store.GetByToken(ctx, token)
 	if err != nil {
 		return client, err
 	}
-	client, ok := clientRes.(AuthClient)
-	if !ok {
-		return client, errors.New("parse client json failed")
-	}
 	currentTime := time.Now().Local()
 	if client.ExpiredTime.Sub(currentTime) <= 0 {
 		return client, errors.New("token is expired")
@@ -257,6 +323,134 @@ func (g *GoAuth) CheckTokenInRedis(ctx context.Context, token string) (AuthClien
 	return client, nil
 }
 
+// RefreshToken exchanges a refresh token for a new access token. The refresh token is
+// rotated: the old token pair is removed and the old refresh token is remembered as used,
+// so presenting it again is treated as a replay and ends the session it belonged to.
+// When clientId is not empty, the refresh token must have been issued to that client.
+func (g *GoAuth) RefreshToken(ctx context.Context, clientId, refreshToken string) (AuthClient, error) {
+	var client AuthClient
+	if refreshToken == "" {
+		return client, errors.New("refresh token is null")
+	}
+	usedSessionKey, err := g.Store.GetUsedRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return client, err
+	}
+	if usedSessionKey != "" {
+		return client, g.refreshTokenReused(ctx, usedSessionKey)
+	}
+	client, err = g.Store.GetByRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return client, err
+	}
+	if client.ClienId == "" || client.RefreshToken != refreshToken {
+		return AuthClient{}, ErrRefreshTokenInvalid
+	} else if clientId != "" && client.ClienId != clientId {
+		return AuthClient{}, ErrRefreshTokenInvalid
+	}
+	currentTime := time.Now().Local()
+	if client.RefreshExpiredTime.Sub(currentTime) <= 0 {
+		if err := g.Store.Delete(ctx, client); err != nil {
+			return AuthClient{}, err
+		}
+		return AuthClient{}, ErrRefreshTokenExpired
+	}
+	if g.ClientRegistry != nil {
+		registered, err := g.ClientRegistry.GetClient(ctx, client.ClienId)
+		if err != nil {
+			return AuthClient{}, err
+		} else if registered == nil || !registered.Enabled {
+			return AuthClient{}, ErrInvalidClient
+		} else if !registered.allowGrantType(GRANT_REFRESH_TOKEN) {
+			return AuthClient{}, ErrUnauthorizedClient
+		}
+	}
+	// the claim is atomic, a concurrent rotation of the same token loses it and is
+	// handled as a reuse
+	claimed, err := g.Store.ClaimRefreshToken(ctx, refreshToken, client.SessionKey(), client.RefreshExpiredTime)
+	if err != nil {
+		return AuthClient{}, err
+	} else if !claimed {
+		return AuthClient{}, g.refreshTokenReused(ctx, client.SessionKey())
+	}
+	if err := g.Store.Delete(ctx, client); err != nil {
+		return AuthClient{}, err
+	}
+	client.UserData = parseJWTData(client.JWT)
+	clientNew := g.mapClientData(client)
+	if err := g.Store.Save(ctx, clientNew); err != nil {
+		return clientNew, err
+	}
+	return g.mapClientResponse(clientNew, true)
+}
+
+// refreshTokenReused revokes the session of a refresh token used twice, one of the
+// two users stole it.
+func (g *GoAuth) refreshTokenReused(ctx context.Context, sessionKey string) error {
+	log.Warnf("refresh token of session %s is reused, revoke current session", sessionKey)
+	current, err := g.Store.GetBySession(ctx, sessionKey)
+	if err != nil {
+		return err
+	}
+	if current.ClienId != "" {
+		if err := g.revokeClient(ctx, current); err != nil {
+			return err
+		}
+	}
+	return ErrRefreshTokenUsed
+}
+
+// Revoke ends the session that owns token, which may be either an access token or a
+// refresh token. The access token is remembered as revoked until it would have expired,
+// so that caches in front of CheckTokenInRedis can refuse it through IsRevoked.
+func (g *GoAuth) Revoke(ctx context.Context, token string) error {
+	if token == "" {
+		return errors.New("token is null")
+	}
+	client, err := g.Store.GetByToken(ctx, token)
+	if err != nil {
+		return err
+	}
+	if client.ClienId == "" {
+		client, err = g.Store.GetByRefreshToken(ctx, token)
+		if err != nil {
+			return err
+		}
+	}
+	if client.ClienId == "" {
+		return nil
+	}
+	return g.revokeClient(ctx, client)
+}
+
+// RevokeAllForUser ends every session issued to userId.
+func (g *GoAuth) RevokeAllForUser(ctx context.Context, userId string) error {
+	if userId == "" {
+		return errors.New("user id is null")
+	}
+	clients, err := g.Store.GetByUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if err := g.revokeClient(ctx, client); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (g *GoAuth) IsRevoked(ctx context.Context, token string) (bool, error) {
+	return g.Store.IsRevoked(ctx, token)
+}
+
+func (g *GoAuth) revokeClient(ctx context.Context, client AuthClient) error {
+	if err := g.Store.Delete(ctx, client); err != nil {
+		return err
+	}
+	return g.Store.MarkRevoked(ctx, client.Token, client.SessionKey(), client.ExpiredTime)
+}
+
 func (g *GoAuth) mapClientResponse(client AuthClient, isRefresh bool) (AuthClient, error) {
 	response := AuthClient{}
 	if client.Token == "" {
@@ -274,19 +468,22 @@ func (g *GoAuth) mapClientResponse(client AuthClient, isRefresh bool) (AuthClien
 			TokenType:   g.TokenType,
 			JWT:         client.JWT,
 			Scopes:      client.Scopes,
+			SessionId:   client.SessionId,
 		}
 	} else {
 		response = AuthClient{
-			CreatedTime:  client.CreatedTime,
-			ClienId:      client.ClienId,
-			UserId:       client.UserId,
-			Token:        client.Token,
-			ExpiredTime:  client.ExpiredTime,
-			ExpiredIn:    int(client.ExpiredTime.Sub(currentTime).Seconds()),
-			TokenType:    g.TokenType,
-			Scopes:       client.Scopes,
-			JWT:          client.JWT,
-			RefreshToken: client.RefreshToken,
+			CreatedTime:        client.CreatedTime,
+			ClienId:            client.ClienId,
+			UserId:             client.UserId,
+			Token:              client.Token,
+			ExpiredTime:        client.ExpiredTime,
+			ExpiredIn:          int(client.ExpiredTime.Sub(currentTime).Seconds()),
+			TokenType:          g.TokenType,
+			Scopes:             client.Scopes,
+			JWT:                client.JWT,
+			RefreshToken:       client.RefreshToken,
+			RefreshExpiredTime: client.RefreshExpiredTime,
+			SessionId:          client.SessionId,
 		}
 	}
 
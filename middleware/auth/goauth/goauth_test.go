@@ -0,0 +1,113 @@
+package goauth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestGoAuth(t *testing.T) (*GoAuth, *MemoryTokenStore) {
+	t.Helper()
+	if JWTKeys == nil {
+		keys, err := NewTemporaryKeySet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		JWTKeys = keys
+	}
+	store := NewMemoryTokenStore()
+	g, err := NewGoAuth(GoAuth{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g.(*GoAuth), store
+}
+
+func TestMemoryClaimRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryTokenStore()
+	expiredTime := time.Now().Add(time.Minute)
+	if _, err := store.ClaimRefreshToken(ctx, "expired", "session", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.ClaimRefreshToken(ctx, "used", "session", expiredTime); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		refreshToken string
+		want         bool
+	}{
+		{"first claim", "fresh", true},
+		{"already claimed", "used", false},
+		{"claim expired", "expired", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := store.ClaimRefreshToken(ctx, tt.refreshToken, "session", expiredTime)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ClaimRefreshToken(%q) = %v, want %v", tt.refreshToken, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	ctx := context.Background()
+	g, store := newTestGoAuth(t)
+	issued, err := g.ClientCredential(ctx, AuthClient{ClienId: "client", UserId: "user"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := g.RefreshToken(ctx, "client", issued.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.RefreshToken == issued.RefreshToken {
+		t.Fatal("refresh token is not rotated")
+	}
+	if _, err := g.RefreshToken(ctx, "client", issued.RefreshToken); !errors.Is(err, ErrRefreshTokenUsed) {
+		t.Fatalf("reused refresh token: got %v, want %v", err, ErrRefreshTokenUsed)
+	}
+	if client, _ := store.GetByToken(ctx, rotated.Token); client.ClienId != "" {
+		t.Error("session of a reused refresh token is not ended")
+	}
+	if revoked, _ := store.IsRevoked(ctx, rotated.Token); !revoked {
+		t.Error("access token of a reused refresh token is not revoked")
+	}
+	if _, err := g.RefreshToken(ctx, "client", rotated.RefreshToken); err == nil {
+		t.Error("refresh token of an ended session is accepted")
+	}
+}
+
+func TestRefreshTokenConcurrent(t *testing.T) {
+	ctx := context.Background()
+	g, _ := newTestGoAuth(t)
+	issued, err := g.ClientCredential(ctx, AuthClient{ClienId: "client", UserId: "user"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.RefreshToken(ctx, "client", issued.RefreshToken); err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if succeeded != 1 {
+		t.Errorf("%d concurrent refreshes succeeded, want 1", succeeded)
+	}
+}
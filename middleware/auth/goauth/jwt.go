@@ -0,0 +1,290 @@
+package goauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// JWT_TYPE_ACCESS is the typ claim of the JWT of an AuthClient, verifiers reject any
+// other typ.
+const JWT_TYPE_ACCESS = "access"
+
+// JWTKeys holds the keys used to sign and verify the JWT of every AuthClient.
+var JWTKeys *KeySet
+
+// JWTAudience is the aud claim of the JWT of every AuthClient.
+var JWTAudience = "callcenter-api"
+
+// SigningKey describes one JWT key as it is written in config. HS256 keys use Secret,
+// RS256 and ES256 keys use a PEM private key, given inline or as a file. A key with
+// only a public key can still verify tokens, which is how a retired key is kept
+// around until the tokens it signed have expired.
+type SigningKey struct {
+	Kid            string `mapstructure:"kid"`
+	Alg            string `mapstructure:"alg"`
+	Secret         string `mapstructure:"secret"`
+	PrivateKey     string `mapstructure:"private_key"`
+	PrivateKeyFile string `mapstructure:"private_key_file"`
+	PublicKey      string `mapstructure:"public_key"`
+	PublicKeyFile  string `mapstructure:"public_key_file"`
+}
+
+type jwtKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+type KeySet struct {
+	activeKid string
+	keys      map[string]*jwtKey
+}
+
+// NewKeySet loads keys and signs new tokens with the key activeKid, or with the first
+// key when activeKid is empty. At least one key is needed.
+func NewKeySet(keys []SigningKey, activeKid string) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwt key is not configured")
+	}
+	k := &KeySet{
+		keys: make(map[string]*jwtKey),
+	}
+	for _, key := range keys {
+		jwtKey, err := loadKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", key.Kid, err)
+		}
+		if _, ok := k.keys[jwtKey.kid]; ok {
+			return nil, fmt.Errorf("jwt key %s is duplicated", jwtKey.kid)
+		}
+		k.keys[jwtKey.kid] = jwtKey
+	}
+	if activeKid == "" {
+		activeKid = keys[0].Kid
+	}
+	active, ok := k.keys[activeKid]
+	if !ok {
+		return nil, fmt.Errorf("active jwt key %s is not found", activeKid)
+	} else if active.signKey == nil {
+		return nil, fmt.Errorf("active jwt key %s has no private key", activeKid)
+	}
+	k.activeKid = activeKid
+	return k, nil
+}
+
+// NewTemporaryKeySet generates an RS256 key in memory. Tokens it signs stop verifying
+// on restart and on other instances, so it only suits the memory store and development.
+func NewTemporaryKeySet() (*KeySet, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	kid := uuid.NewString()
+	return &KeySet{
+		activeKid: kid,
+		keys: map[string]*jwtKey{
+			kid: {
+				kid:       kid,
+				method:    jwt.SigningMethodRS256,
+				signKey:   privateKey,
+				verifyKey: &privateKey.PublicKey,
+			},
+		},
+	}, nil
+}
+
+// NewVerifyKeySet loads keys that only verify tokens signed elsewhere, public keys
+// are enough and at least one key is needed.
+func NewVerifyKeySet(keys []SigningKey) (*KeySet, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("jwt key is not configured")
+	}
+	k := &KeySet{
+		keys:      make(map[string]*jwtKey),
+		activeKid: keys[0].Kid,
+	}
+	for _, key := range keys {
+		jwtKey, err := loadKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("jwt key %s: %w", key.Kid, err)
+		}
+		if _, ok := k.keys[jwtKey.kid]; ok {
+			return nil, fmt.Errorf("jwt key %s is duplicated", jwtKey.kid)
+		}
+		k.keys[jwtKey.kid] = jwtKey
+	}
+	return k, nil
+}
+
+func loadKey(key SigningKey) (*jwtKey, error) {
+	if key.Kid == "" {
+		return nil, errors.New("kid is missing")
+	}
+	privateKey, err := readPEM(key.PrivateKey, key.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := readPEM(key.PublicKey, key.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	result := &jwtKey{kid: key.Kid}
+	switch key.Alg {
+	case jwt.SigningMethodHS256.Alg():
+		if key.Secret == "" {
+			return nil, errors.New("secret is missing")
+		}
+		result.method = jwt.SigningMethodHS256
+		result.signKey = []byte(key.Secret)
+		result.verifyKey = []byte(key.Secret)
+	case jwt.SigningMethodRS256.Alg():
+		result.method = jwt.SigningMethodRS256
+		if len(privateKey) > 0 {
+			signKey, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+			if err != nil {
+				return nil, err
+			}
+			result.signKey = signKey
+			result.verifyKey = &signKey.PublicKey
+		} else if len(publicKey) > 0 {
+			result.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(publicKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+	case jwt.SigningMethodES256.Alg():
+		result.method = jwt.SigningMethodES256
+		if len(privateKey) > 0 {
+			signKey, err := jwt.ParseECPrivateKeyFromPEM(privateKey)
+			if err != nil {
+				return nil, err
+			}
+			result.signKey = signKey
+			result.verifyKey = &signKey.PublicKey
+		} else if len(publicKey) > 0 {
+			result.verifyKey, err = jwt.ParseECPublicKeyFromPEM(publicKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, fmt.Errorf("alg %s is not supported", key.Alg)
+	}
+	if result.verifyKey == nil {
+		return nil, errors.New("private key or public key is missing")
+	}
+	return result, nil
+}
+
+func readPEM(value, file string) ([]byte, error) {
+	if value != "" {
+		return []byte(value), nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
+
+// Sign signs claims with the active key and sets the kid header.
+func (k *KeySet) Sign(claims jwt.MapClaims) (string, error) {
+	key := k.keys[k.activeKid]
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.signKey)
+}
+
+// Parse verifies tokenString against the key named by its kid header, falling back
+// to the active key when the header is missing, and returns its claims.
+func (k *KeySet) Parse(tokenString string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			kid = k.activeKid
+		}
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown kid: %v", kid)
+		}
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.verifyKey, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// JWKS returns the public keys as a JSON Web Key Set (RFC 7517). HS256 keys are
+// shared secrets and are never published.
+func (k *KeySet) JWKS() map[string]interface{} {
+	kids := make([]string, 0, len(k.keys))
+	for kid := range k.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	keys := make([]map[string]interface{}, 0)
+	for _, kid := range kids {
+		key := k.keys[kid]
+		switch publicKey := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]interface{}{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": key.method.Alg(),
+				"kid": key.kid,
+				"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			size := (publicKey.Curve.Params().BitSize + 7) / 8
+			keys = append(keys, map[string]interface{}{
+				"kty": "EC",
+				"use": "sig",
+				"alg": key.method.Alg(),
+				"kid": key.kid,
+				"crv": publicKey.Curve.Params().Name,
+				"x":   base64.RawURLEncoding.EncodeToString(publicKey.X.FillBytes(make([]byte, size))),
+				"y":   base64.RawURLEncoding.EncodeToString(publicKey.Y.FillBytes(make([]byte, size))),
+			})
+		}
+	}
+	return map[string]interface{}{
+		"keys": keys,
+	}
+}
+
+// ParseJWT verifies a JWT issued by GenerateJWT and returns its claims.
+func ParseJWT(tokenString string) (jwt.MapClaims, error) {
+	if JWTKeys == nil {
+		return nil, errors.New("jwt keys are not configured")
+	}
+	return JWTKeys.Parse(tokenString)
+}
+
+// JWKS returns the public keys of JWTKeys as a JSON Web Key Set.
+func JWKS() map[string]interface{} {
+	if JWTKeys == nil {
+		return map[string]interface{}{
+			"keys": []interface{}{},
+		}
+	}
+	return JWTKeys.JWKS()
+}
@@ -0,0 +1,28 @@
+package goauth
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// RegisterMetrics registers the goauth_live_sessions gauge of store with the default
+// Prometheus registry.
+func RegisterMetrics(store TokenStore) error {
+	return prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "goauth_live_sessions",
+		Help: "Number of access tokens issued by goauth that have not expired.",
+	}, func() float64 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		total, err := store.CountSessions(ctx)
+		if err != nil {
+			log.Error(err)
+			return math.NaN()
+		}
+		return float64(total)
+	}))
+}
@@ -0,0 +1,86 @@
+package goauth
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// MFA_PENDING is the typ and aud of a token proving the password of a user that still
+// has to pass its second factor.
+const MFA_PENDING = "mfa_pending"
+
+var ErrMfaTokenInvalid = errors.New("mfa token is invalid")
+
+// mfaKeys signs the mfa_pending tokens. It is kept apart from JWTKeys so that the
+// verifiers of access tokens through the JWKS never accept a pending token.
+var mfaKeys *KeySet
+
+// SetMfaSecret sets the HS256 secret of the mfa_pending tokens, every instance needs
+// the same one.
+func SetMfaSecret(secret string) error {
+	keys, err := NewKeySet([]SigningKey{{Kid: MFA_PENDING, Alg: jwt.SigningMethodHS256.Alg(), Secret: secret}}, "")
+	if err != nil {
+		return err
+	}
+	mfaKeys = keys
+	return nil
+}
+
+// NewMfaPendingToken signs the request of client, which is resumed by
+// ParseMfaPendingToken once the second factor is verified.
+func NewMfaPendingToken(client AuthClient, expiredIn int) (string, error) {
+	claims := jwt.MapClaims{}
+	for key, value := range client.UserData {
+		claims[key] = value
+	}
+	currentTime := time.Now()
+	claims["typ"] = MFA_PENDING
+	claims["aud"] = MFA_PENDING
+	claims["sub"] = client.UserId
+	claims["client_id"] = client.ClienId
+	claims["grant_type"] = client.GrantType
+	claims["scope"] = strings.Join(client.Scopes, " ")
+	claims["device_id"] = client.DeviceId
+	claims["iat"] = currentTime.Unix()
+	claims["exp"] = currentTime.Add(time.Duration(expiredIn) * time.Second).Unix()
+	if mfaKeys == nil {
+		return "", errors.New("mfa secret is not configured")
+	}
+	return mfaKeys.Sign(claims)
+}
+
+// ParseMfaPendingToken verifies a token of NewMfaPendingToken and returns its client.
+func ParseMfaPendingToken(tokenString string) (AuthClient, error) {
+	client := AuthClient{}
+	if mfaKeys == nil {
+		return client, ErrMfaTokenInvalid
+	}
+	claims, err := mfaKeys.Parse(tokenString)
+	if err != nil {
+		return client, ErrMfaTokenInvalid
+	}
+	if typ, _ := claims["typ"].(string); typ != MFA_PENDING || !claims.VerifyAudience(MFA_PENDING, true) {
+		return client, ErrMfaTokenInvalid
+	}
+	client.UserId, _ = claims["sub"].(string)
+	client.ClienId, _ = claims["client_id"].(string)
+	client.GrantType, _ = claims["grant_type"].(string)
+	client.DeviceId, _ = claims["device_id"].(string)
+	scope, _ := claims["scope"].(string)
+	client.Scopes = strings.Fields(scope)
+	if len(client.UserId) < 1 || len(client.ClienId) < 1 {
+		return client, ErrMfaTokenInvalid
+	}
+	client.UserData = make(map[string]interface{})
+	for key, value := range claims {
+		switch key {
+		case "typ", "aud", "sub", "client_id", "grant_type", "scope", "device_id", "iat", "exp":
+		default:
+			client.UserData[key] = value
+		}
+	}
+	return client, nil
+}
@@ -0,0 +1,35 @@
+package goauth
+
+import "strings"
+
+// SCOPE_ALL is granted to credentials that are not narrowed to any scope.
+const SCOPE_ALL = "*"
+
+// MatchScope reports whether the granted scope covers scope. A granted scope ending
+// with ":*" covers every scope under its prefix, so "cdr:*" covers "cdr:read".
+func MatchScope(granted, scope string) bool {
+	if granted == SCOPE_ALL || granted == scope {
+		return true
+	}
+	if strings.HasSuffix(granted, ":*") {
+		return strings.HasPrefix(scope, strings.TrimSuffix(granted, "*"))
+	}
+	return false
+}
+
+// HasScope reports whether any of the granted scopes covers scope.
+func HasScope(granted []string, scope string) bool {
+	for _, value := range granted {
+		if MatchScope(value, scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseScope splits a space or comma separated scope string.
+func ParseScope(scope string) []string {
+	return strings.FieldsFunc(scope, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+}
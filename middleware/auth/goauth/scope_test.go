@@ -0,0 +1,57 @@
+package goauth
+
+import "testing"
+
+func TestMatchScope(t *testing.T) {
+	tests := []struct {
+		granted string
+		scope   string
+		want    bool
+	}{
+		{SCOPE_ALL, "cdr:read", true},
+		{"cdr:read", "cdr:read", true},
+		{"cdr:read", "cdr:write", false},
+		{"cdr:*", "cdr:read", true},
+		{"cdr:*", "cdr:", true},
+		{"cdr:*", "cdr", false},
+		{"cdr:*", "cdrx:read", false},
+		{"cdr*", "cdr:read", false},
+		{"", "cdr:read", false},
+	}
+	for _, tt := range tests {
+		if got := MatchScope(tt.granted, tt.scope); got != tt.want {
+			t.Errorf("MatchScope(%q, %q) = %v, want %v", tt.granted, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		granted []string
+		scope   string
+		want    bool
+	}{
+		{nil, "cdr:read", false},
+		{[]string{}, "cdr:read", false},
+		{[]string{"user:read", "cdr:*"}, "cdr:read", true},
+		{[]string{"user:read"}, "cdr:read", false},
+	}
+	for _, tt := range tests {
+		if got := HasScope(tt.granted, tt.scope); got != tt.want {
+			t.Errorf("HasScope(%v, %q) = %v, want %v", tt.granted, tt.scope, got, tt.want)
+		}
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	got := ParseScope(" cdr:read,user:read  user:write,")
+	want := []string{"cdr:read", "user:read", "user:write"}
+	if len(got) != len(want) {
+		t.Fatalf("ParseScope = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("ParseScope = %v, want %v", got, want)
+		}
+	}
+}
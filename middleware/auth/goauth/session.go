@@ -0,0 +1,124 @@
+package goauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrSessionNotFound = errors.New("session is not found")
+
+// Session describes a session of a user without its tokens.
+type Session struct {
+	SessionId          string    `json:"session_id"`
+	ClientId           string    `json:"client_id"`
+	UserId             string    `json:"user_id"`
+	DeviceId           string    `json:"device_id,omitempty"`
+	UserAgent          string    `json:"user_agent,omitempty"`
+	IpAddress          string    `json:"ip_address,omitempty"`
+	CreatedTime        time.Time `json:"created_at"`
+	ExpiredTime        time.Time `json:"expired_at"`
+	RefreshExpiredTime time.Time `json:"refresh_expired_at"`
+}
+
+// newSessionId returns a new random session id, or the same id for the same deviceId.
+func newSessionId(deviceId string) string {
+	if deviceId == "" {
+		return strings.Replace(uuid.NewString(), "-", "", -1)
+	}
+	hash := sha256.Sum256([]byte(deviceId))
+	return hex.EncodeToString(hash[:16])
+}
+
+func (c AuthClient) session() Session {
+	sessionId := c.SessionId
+	if sessionId == "" {
+		sessionId = c.SessionKey()
+	}
+	return Session{
+		SessionId:          sessionId,
+		ClientId:           c.ClienId,
+		UserId:             c.UserId,
+		DeviceId:           c.DeviceId,
+		UserAgent:          c.UserAgent,
+		IpAddress:          c.IpAddress,
+		CreatedTime:        c.CreatedTime,
+		ExpiredTime:        c.ExpiredTime,
+		RefreshExpiredTime: c.RefreshExpiredTime,
+	}
+}
+
+// liveSessions returns the sessions of userId that can still be used or refreshed,
+// oldest first.
+func (g *GoAuth) liveSessions(ctx context.Context, userId string) ([]AuthClient, error) {
+	clients, err := g.Store.GetByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	currentTime := time.Now()
+	result := make([]AuthClient, 0, len(clients))
+	for _, client := range clients {
+		if currentTime.Before(client.ExpiredTime) || currentTime.Before(client.RefreshExpiredTime) {
+			result = append(result, client)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedTime.Before(result[j].CreatedTime)
+	})
+	return result, nil
+}
+
+func (g *GoAuth) ListSessions(ctx context.Context, userId string) ([]Session, error) {
+	if userId == "" {
+		return nil, errors.New("user id is null")
+	}
+	clients, err := g.liveSessions(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]Session, 0, len(clients))
+	for _, client := range clients {
+		sessions = append(sessions, client.session())
+	}
+	return sessions, nil
+}
+
+func (g *GoAuth) RevokeSession(ctx context.Context, userId, sessionId string) error {
+	if userId == "" || sessionId == "" {
+		return ErrSessionNotFound
+	}
+	clients, err := g.Store.GetByUser(ctx, userId)
+	if err != nil {
+		return err
+	}
+	for _, client := range clients {
+		if client.session().SessionId == sessionId {
+			return g.revokeClient(ctx, client)
+		}
+	}
+	return ErrSessionNotFound
+}
+
+// enforceSessionLimit ends the oldest sessions of userId so that one more session
+// fits in MaxSessions.
+func (g *GoAuth) enforceSessionLimit(ctx context.Context, userId string) error {
+	if g.MaxSessions <= 0 || userId == "" {
+		return nil
+	}
+	clients, err := g.liveSessions(ctx, userId)
+	if err != nil {
+		return err
+	}
+	for i := 0; i <= len(clients)-g.MaxSessions; i++ {
+		if err := g.revokeClient(ctx, clients[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package goauth
+
+import (
+	"context"
+	"time"
+)
+
+// TokenStore persists the sessions issued by GoAuth. Every getter returns an empty
+// AuthClient, not an error, when nothing is found.
+type TokenStore interface {
+	Save(ctx context.Context, client AuthClient) error
+	Delete(ctx context.Context, client AuthClient) error
+	GetBySession(ctx context.Context, sessionKey string) (AuthClient, error)
+	GetByToken(ctx context.Context, token string) (AuthClient, error)
+	GetByRefreshToken(ctx context.Context, refreshToken string) (AuthClient, error)
+	GetByUser(ctx context.Context, userId string) ([]AuthClient, error)
+	// ClaimRefreshToken marks a refresh token as used until expiredTime and reports
+	// whether this call did, so that only one of concurrent rotations succeeds.
+	// GetUsedRefreshToken returns the session key it belonged to.
+	ClaimRefreshToken(ctx context.Context, refreshToken, sessionKey string, expiredTime time.Time) (bool, error)
+	GetUsedRefreshToken(ctx context.Context, refreshToken string) (string, error)
+	// MarkRevoked remembers a revoked access token until expiredTime.
+	MarkRevoked(ctx context.Context, token, sessionKey string, expiredTime time.Time) error
+	IsRevoked(ctx context.Context, token string) (bool, error)
+	// CountSessions returns the number of access tokens that have not expired.
+	CountSessions(ctx context.Context) (int64, error)
+}
@@ -0,0 +1,164 @@
+package goauth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const memoryCleanupInterval = time.Minute
+
+type memoryMarker struct {
+	sessionKey  string
+	expiredTime time.Time
+}
+
+// MemoryTokenStore keeps sessions in process memory. It suits a single instance and
+// tests, sessions are lost on restart.
+type MemoryTokenStore struct {
+	mu                sync.RWMutex
+	sessions          map[string]AuthClient
+	tokens            map[string]AuthClient
+	refreshTokens     map[string]AuthClient
+	usedRefreshTokens map[string]memoryMarker
+	revokedTokens     map[string]memoryMarker
+	lastCleanup       time.Time
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{
+		sessions:          make(map[string]AuthClient),
+		tokens:            make(map[string]AuthClient),
+		refreshTokens:     make(map[string]AuthClient),
+		usedRefreshTokens: make(map[string]memoryMarker),
+		revokedTokens:     make(map[string]memoryMarker),
+		lastCleanup:       time.Now(),
+	}
+}
+
+func (s *MemoryTokenStore) GetBySession(ctx context.Context, sessionKey string) (AuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.sessions[sessionKey], nil
+}
+
+func (s *MemoryTokenStore) GetByToken(ctx context.Context, token string) (AuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tokens[token], nil
+}
+
+func (s *MemoryTokenStore) GetByRefreshToken(ctx context.Context, refreshToken string) (AuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.refreshTokens[refreshToken], nil
+}
+
+func (s *MemoryTokenStore) GetByUser(ctx context.Context, userId string) ([]AuthClient, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	clients := make([]AuthClient, 0)
+	for _, client := range s.sessions {
+		if client.UserId == userId {
+			clients = append(clients, client)
+		}
+	}
+	return clients, nil
+}
+
+func (s *MemoryTokenStore) Save(ctx context.Context, client AuthClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanup()
+	s.sessions[client.SessionKey()] = client
+	s.tokens[client.Token] = client
+	if client.RefreshToken != "" {
+		s.refreshTokens[client.RefreshToken] = client
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) Delete(ctx context.Context, client AuthClient) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, client.SessionKey())
+	delete(s.tokens, client.Token)
+	if client.RefreshToken != "" {
+		delete(s.refreshTokens, client.RefreshToken)
+	}
+	return nil
+}
+
+func (s *MemoryTokenStore) ClaimRefreshToken(ctx context.Context, refreshToken, sessionKey string, expiredTime time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if marker, ok := s.usedRefreshTokens[refreshToken]; ok && time.Now().Before(marker.expiredTime) {
+		return false, nil
+	}
+	s.usedRefreshTokens[refreshToken] = memoryMarker{sessionKey: sessionKey, expiredTime: expiredTime}
+	return true, nil
+}
+
+func (s *MemoryTokenStore) GetUsedRefreshToken(ctx context.Context, refreshToken string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	marker, ok := s.usedRefreshTokens[refreshToken]
+	if !ok || time.Now().After(marker.expiredTime) {
+		return "", nil
+	}
+	return marker.sessionKey, nil
+}
+
+func (s *MemoryTokenStore) MarkRevoked(ctx context.Context, token, sessionKey string, expiredTime time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.revokedTokens[token] = memoryMarker{sessionKey: sessionKey, expiredTime: expiredTime}
+	return nil
+}
+
+func (s *MemoryTokenStore) IsRevoked(ctx context.Context, token string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	marker, ok := s.revokedTokens[token]
+	return ok && time.Now().Before(marker.expiredTime), nil
+}
+
+func (s *MemoryTokenStore) CountSessions(ctx context.Context) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	currentTime := time.Now()
+	var total int64
+	for _, client := range s.tokens {
+		if currentTime.Before(client.ExpiredTime) {
+			total++
+		}
+	}
+	return total, nil
+}
+
+// cleanup drops sessions whose refresh token has expired and stale markers, at most
+// once per memoryCleanupInterval. The caller must hold the write lock.
+func (s *MemoryTokenStore) cleanup() {
+	currentTime := time.Now()
+	if currentTime.Sub(s.lastCleanup) < memoryCleanupInterval {
+		return
+	}
+	s.lastCleanup = currentTime
+	for key, client := range s.sessions {
+		if currentTime.After(client.ExpiredTime) && currentTime.After(client.RefreshExpiredTime) {
+			delete(s.sessions, key)
+			delete(s.tokens, client.Token)
+			delete(s.refreshTokens, client.RefreshToken)
+		}
+	}
+	for key, marker := range s.usedRefreshTokens {
+		if currentTime.After(marker.expiredTime) {
+			delete(s.usedRefreshTokens, key)
+		}
+	}
+	for key, marker := range s.revokedTokens {
+		if currentTime.After(marker.expiredTime) {
+			delete(s.revokedTokens, key)
+		}
+	}
+}
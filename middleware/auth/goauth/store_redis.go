@@ -0,0 +1,330 @@
+package goauth
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	userIndexKey = "access_user_index"
+	liveKey      = "access_live_key"
+	sweepCount   = 100
+)
+
+// RedisTokenStore keeps every session under its own keys, "<TokenKey>:<token>",
+// "<UserKey>:<session key>" and "<RefreshKey>:<refresh token>", so that Redis expires
+// them together with the tokens. The sessions of a user are indexed in the sorted set
+// "<UserIndexKey>:<user id>" and live access tokens in the sorted set LiveKey, both
+// scored by expiry time.
+//
+// Sessions written by older versions as fields of the hashes TokenKey, UserKey and
+// RefreshKey are still read, and are removed by Sweep once expired.
+type RedisTokenStore struct {
+	Client         *redis.Client
+	TokenKey       string
+	UserKey        string
+	RefreshKey     string
+	RefreshUsedKey string
+	RevokedKey     string
+	UserIndexKey   string
+	LiveKey        string
+}
+
+func NewRedisTokenStore(client *redis.Client) *RedisTokenStore {
+	return &RedisTokenStore{
+		Client:         client,
+		TokenKey:       tokenKey,
+		UserKey:        userKey,
+		RefreshKey:     refreshKey,
+		RefreshUsedKey: refreshUsedKey,
+		RevokedKey:     revokedKey,
+		UserIndexKey:   userIndexKey,
+		LiveKey:        liveKey,
+	}
+}
+
+func (s *RedisTokenStore) getClient(ctx context.Context, key, field string) (AuthClient, error) {
+	var authClient AuthClient
+	res, err := s.Client.Get(ctx, key+":"+field).Result()
+	if err == redis.Nil {
+		res, err = s.Client.HGet(ctx, key, field).Result()
+	}
+	if err == redis.Nil {
+		return authClient, nil
+	} else if err != nil {
+		return authClient, err
+	}
+	if err := json.Unmarshal([]byte(res), &authClient); err != nil {
+		log.Error(err)
+		return authClient, err
+	}
+	return authClient, nil
+}
+
+func (s *RedisTokenStore) GetBySession(ctx context.Context, sessionKey string) (AuthClient, error) {
+	return s.getClient(ctx, s.UserKey, sessionKey)
+}
+
+func (s *RedisTokenStore) GetByToken(ctx context.Context, token string) (AuthClient, error) {
+	return s.getClient(ctx, s.TokenKey, token)
+}
+
+func (s *RedisTokenStore) GetByRefreshToken(ctx context.Context, refreshToken string) (AuthClient, error) {
+	return s.getClient(ctx, s.RefreshKey, refreshToken)
+}
+
+func (s *RedisTokenStore) GetByUser(ctx context.Context, userId string) ([]AuthClient, error) {
+	indexKey := s.UserIndexKey + ":" + userId
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := s.Client.ZRemRangeByScore(ctx, indexKey, "-inf", "("+now).Err(); err != nil {
+		return nil, err
+	}
+	sessionKeys, err := s.Client.ZRange(ctx, indexKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	clients := make([]AuthClient, 0)
+	for _, sessionKey := range sessionKeys {
+		client, err := s.GetBySession(ctx, sessionKey)
+		if err != nil {
+			return nil, err
+		}
+		if client.ClienId == "" {
+			s.Client.ZRem(ctx, indexKey, sessionKey)
+			continue
+		}
+		clients = append(clients, client)
+	}
+	legacy, err := s.getLegacyByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return append(clients, legacy...), nil
+}
+
+// getLegacyByUser scans the whole legacy UserKey hash and keeps the sessions of userId.
+// Its fields are keyed by client id, which need not contain the user id, so they are
+// matched on the stored UserId. The scan is empty once Sweep drained the hash.
+func (s *RedisTokenStore) getLegacyByUser(ctx context.Context, userId string) ([]AuthClient, error) {
+	clients := make([]AuthClient, 0)
+	var cursor uint64
+	for {
+		res, next, err := s.Client.HScan(ctx, s.UserKey, cursor, "", sweepCount).Result()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i+1 < len(res); i += 2 {
+			var client AuthClient
+			if err := json.Unmarshal([]byte(res[i+1]), &client); err != nil {
+				log.Error(err)
+				continue
+			}
+			if client.UserId == userId {
+				clients = append(clients, client)
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			return clients, nil
+		}
+	}
+}
+
+func (s *RedisTokenStore) Save(ctx context.Context, client AuthClient) error {
+	jsonClient, err := json.Marshal(client)
+	if err != nil {
+		return err
+	}
+	sessionKey := client.SessionKey()
+	sessionExpiredTime := client.ExpiredTime
+	if client.RefreshExpiredTime.After(sessionExpiredTime) {
+		sessionExpiredTime = client.RefreshExpiredTime
+	}
+	tokenTTL := time.Until(client.ExpiredTime)
+	sessionTTL := time.Until(sessionExpiredTime)
+	if sessionTTL <= 0 {
+		return nil
+	}
+	indexKey := s.UserIndexKey + ":" + client.UserId
+	indexTTL, err := s.Client.TTL(ctx, indexKey).Result()
+	if err != nil {
+		return err
+	}
+	_, err = s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, s.UserKey+":"+sessionKey, jsonClient, sessionTTL)
+		if tokenTTL > 0 {
+			pipe.Set(ctx, s.TokenKey+":"+client.Token, jsonClient, tokenTTL)
+			pipe.ZAdd(ctx, s.LiveKey, &redis.Z{Score: float64(client.ExpiredTime.Unix()), Member: client.Token})
+		}
+		if refreshTTL := time.Until(client.RefreshExpiredTime); client.RefreshToken != "" && refreshTTL > 0 {
+			pipe.Set(ctx, s.RefreshKey+":"+client.RefreshToken, jsonClient, refreshTTL)
+		}
+		pipe.ZAdd(ctx, indexKey, &redis.Z{Score: float64(sessionExpiredTime.Unix()), Member: sessionKey})
+		if indexTTL < sessionTTL {
+			pipe.Expire(ctx, indexKey, sessionTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisTokenStore) Delete(ctx context.Context, client AuthClient) error {
+	sessionKey := client.SessionKey()
+	_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.UserKey+":"+sessionKey, s.TokenKey+":"+client.Token)
+		pipe.ZRem(ctx, s.UserIndexKey+":"+client.UserId, sessionKey)
+		pipe.ZRem(ctx, s.LiveKey, client.Token)
+		pipe.HDel(ctx, s.UserKey, sessionKey)
+		pipe.HDel(ctx, s.TokenKey, client.Token)
+		if client.RefreshToken != "" {
+			pipe.Del(ctx, s.RefreshKey+":"+client.RefreshToken)
+			pipe.HDel(ctx, s.RefreshKey, client.RefreshToken)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisTokenStore) ClaimRefreshToken(ctx context.Context, refreshToken, sessionKey string, expiredTime time.Time) (bool, error) {
+	ttl := time.Until(expiredTime)
+	if ttl <= 0 {
+		return false, nil
+	}
+	return s.Client.SetNX(ctx, s.RefreshUsedKey+":"+refreshToken, sessionKey, ttl).Result()
+}
+
+func (s *RedisTokenStore) GetUsedRefreshToken(ctx context.Context, refreshToken string) (string, error) {
+	sessionKey, err := s.Client.Get(ctx, s.RefreshUsedKey+":"+refreshToken).Result()
+	if err == redis.Nil {
+		sessionKey, err = s.Client.HGet(ctx, s.RefreshUsedKey, refreshToken).Result()
+	}
+	if err == redis.Nil {
+		return "", nil
+	}
+	return sessionKey, err
+}
+
+func (s *RedisTokenStore) MarkRevoked(ctx context.Context, token, sessionKey string, expiredTime time.Time) error {
+	ttl := time.Until(expiredTime)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.Client.Set(ctx, s.RevokedKey+":"+token, sessionKey, ttl).Err()
+}
+
+func (s *RedisTokenStore) IsRevoked(ctx context.Context, token string) (bool, error) {
+	res, err := s.Client.Exists(ctx, s.RevokedKey+":"+token).Result()
+	if err != nil {
+		return false, err
+	}
+	return res > 0, nil
+}
+
+func (s *RedisTokenStore) CountSessions(ctx context.Context) (int64, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := s.Client.ZRemRangeByScore(ctx, s.LiveKey, "-inf", "("+now).Err(); err != nil {
+		return 0, err
+	}
+	return s.Client.ZCard(ctx, s.LiveKey).Result()
+}
+
+// Sweep removes expired sessions from the legacy hashes, and used refresh token
+// markers whose session is gone.
+func (s *RedisTokenStore) Sweep(ctx context.Context) error {
+	currentTime := time.Now()
+	legacyKeys := map[string]func(client AuthClient) bool{
+		s.TokenKey: func(client AuthClient) bool {
+			return currentTime.After(client.ExpiredTime)
+		},
+		s.UserKey: func(client AuthClient) bool {
+			return currentTime.After(client.ExpiredTime) && currentTime.After(client.RefreshExpiredTime)
+		},
+		s.RefreshKey: func(client AuthClient) bool {
+			return currentTime.After(client.RefreshExpiredTime)
+		},
+	}
+	for key, isExpired := range legacyKeys {
+		total, err := s.sweepHash(ctx, key, func(field, value string) (bool, error) {
+			var client AuthClient
+			if err := json.Unmarshal([]byte(value), &client); err != nil {
+				return true, nil
+			}
+			return isExpired(client), nil
+		})
+		if err != nil {
+			return err
+		}
+		if total > 0 {
+			log.Infof("goauth sweeper removed %d expired entries from %s", total, key)
+		}
+	}
+	total, err := s.sweepHash(ctx, s.RefreshUsedKey, func(field, value string) (bool, error) {
+		client, err := s.GetBySession(ctx, value)
+		if err != nil {
+			return false, err
+		}
+		return client.ClienId == "", nil
+	})
+	if err != nil {
+		return err
+	}
+	if total > 0 {
+		log.Infof("goauth sweeper removed %d entries from %s", total, s.RefreshUsedKey)
+	}
+	now := strconv.FormatInt(currentTime.Unix(), 10)
+	return s.Client.ZRemRangeByScore(ctx, s.LiveKey, "-inf", "("+now).Err()
+}
+
+func (s *RedisTokenStore) sweepHash(ctx context.Context, key string, isExpired func(field, value string) (bool, error)) (int, error) {
+	var cursor uint64
+	total := 0
+	for {
+		res, next, err := s.Client.HScan(ctx, key, cursor, "", sweepCount).Result()
+		if err != nil {
+			return total, err
+		}
+		fields := make([]string, 0)
+		for i := 0; i+1 < len(res); i += 2 {
+			expired, err := isExpired(res[i], res[i+1])
+			if err != nil {
+				return total, err
+			}
+			if expired {
+				fields = append(fields, res[i])
+			}
+		}
+		if len(fields) > 0 {
+			if err := s.Client.HDel(ctx, key, fields...).Err(); err != nil {
+				return total, err
+			}
+			total += len(fields)
+		}
+		cursor = next
+		if cursor == 0 {
+			return total, nil
+		}
+	}
+}
+
+// StartSweeper runs Sweep every interval until ctx is done.
+func (s *RedisTokenStore) StartSweeper(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Sweep(ctx); err != nil {
+					log.Error(err)
+				}
+			}
+		}
+	}()
+}
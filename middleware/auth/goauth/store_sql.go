@@ -0,0 +1,220 @@
+package goauth
+
+import (
+	"callcenter-api/internal/sqlclient"
+	"callcenter-api/repository"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+const (
+	markerRevoked     = "revoked"
+	markerRefreshUsed = "refresh_used"
+)
+
+type sqlToken struct {
+	bun.BaseModel    `bun:"table:goauth_tokens,alias:gt"`
+	Token            string    `bun:"token,pk,type:varchar(255)"`
+	SessionKey       string    `bun:"session_key,type:varchar(255),notnull"`
+	UserId           string    `bun:"user_id,type:varchar(255)"`
+	RefreshToken     string    `bun:"refresh_token,type:varchar(255)"`
+	ExpiredAt        time.Time `bun:"expired_at,type:timestamp,notnull"`
+	RefreshExpiredAt time.Time `bun:"refresh_expired_at,type:timestamp,notnull"`
+	Data             string    `bun:"data,type:text"`
+}
+
+type sqlTokenMarker struct {
+	bun.BaseModel `bun:"table:goauth_token_markers,alias:gtm"`
+	Token         string    `bun:"token,pk,type:varchar(255)"`
+	Kind          string    `bun:"kind,pk,type:varchar(20)"`
+	SessionKey    string    `bun:"session_key,type:varchar(255)"`
+	ExpiredAt     time.Time `bun:"expired_at,type:timestamp,notnull"`
+}
+
+// SqlTokenStore keeps sessions in the goauth_tokens table. Expired rows are removed
+// when new sessions are saved.
+type SqlTokenStore struct {
+	client sqlclient.ISqlClientConn
+}
+
+func NewSqlTokenStore(client sqlclient.ISqlClientConn) (*SqlTokenStore, error) {
+	ctx := context.Background()
+	if err := repository.CreateTable(client, ctx, (*sqlToken)(nil)); err != nil {
+		return nil, err
+	}
+	if err := repository.CreateTable(client, ctx, (*sqlTokenMarker)(nil)); err != nil {
+		return nil, err
+	}
+	for _, column := range []string{"session_key", "user_id", "refresh_token"} {
+		_, err := client.GetDB().NewCreateIndex().Model((*sqlToken)(nil)).
+			IfNotExists().
+			Index("goauth_tokens_" + column + "_idx").
+			Column(column).
+			Exec(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &SqlTokenStore{client: client}, nil
+}
+
+func (s *SqlTokenStore) getClient(ctx context.Context, column, value string) (AuthClient, error) {
+	var authClient AuthClient
+	row := new(sqlToken)
+	err := s.client.GetDB().NewSelect().Model(row).
+		Where("? = ?", bun.Ident(column), value).
+		Limit(1).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		return authClient, nil
+	} else if err != nil {
+		return authClient, err
+	}
+	err = json.Unmarshal([]byte(row.Data), &authClient)
+	return authClient, err
+}
+
+func (s *SqlTokenStore) GetBySession(ctx context.Context, sessionKey string) (AuthClient, error) {
+	return s.getClient(ctx, "session_key", sessionKey)
+}
+
+func (s *SqlTokenStore) GetByToken(ctx context.Context, token string) (AuthClient, error) {
+	return s.getClient(ctx, "token", token)
+}
+
+func (s *SqlTokenStore) GetByRefreshToken(ctx context.Context, refreshToken string) (AuthClient, error) {
+	return s.getClient(ctx, "refresh_token", refreshToken)
+}
+
+func (s *SqlTokenStore) GetByUser(ctx context.Context, userId string) ([]AuthClient, error) {
+	rows := make([]sqlToken, 0)
+	err := s.client.GetDB().NewSelect().Model(&rows).
+		Where("user_id = ?", userId).
+		Scan(ctx)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	clients := make([]AuthClient, 0, len(rows))
+	for _, row := range rows {
+		var client AuthClient
+		if err := json.Unmarshal([]byte(row.Data), &client); err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+func (s *SqlTokenStore) Save(ctx context.Context, client AuthClient) error {
+	jsonClient, err := json.Marshal(client)
+	if err != nil {
+		return err
+	}
+	currentTime := time.Now()
+	_, err = s.client.GetDB().NewDelete().Model((*sqlToken)(nil)).
+		Where("expired_at < ?", currentTime).
+		Where("refresh_expired_at < ?", currentTime).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.GetDB().NewDelete().Model((*sqlTokenMarker)(nil)).
+		Where("expired_at < ?", currentTime).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	row := &sqlToken{
+		Token:            client.Token,
+		SessionKey:       client.SessionKey(),
+		UserId:           client.UserId,
+		RefreshToken:     client.RefreshToken,
+		ExpiredAt:        client.ExpiredTime,
+		RefreshExpiredAt: client.RefreshExpiredTime,
+		Data:             string(jsonClient),
+	}
+	_, err = s.client.GetDB().NewInsert().Model(row).Exec(ctx)
+	return err
+}
+
+func (s *SqlTokenStore) Delete(ctx context.Context, client AuthClient) error {
+	_, err := s.client.GetDB().NewDelete().Model((*sqlToken)(nil)).
+		Where("token = ?", client.Token).
+		Exec(ctx)
+	return err
+}
+
+func (s *SqlTokenStore) insertMarker(ctx context.Context, token, kind, sessionKey string, expiredTime time.Time) error {
+	row := &sqlTokenMarker{
+		Token:      token,
+		Kind:       kind,
+		SessionKey: sessionKey,
+		ExpiredAt:  expiredTime,
+	}
+	_, err := s.client.GetDB().NewInsert().Model(row).
+		Ignore().
+		Exec(ctx)
+	return err
+}
+
+func (s *SqlTokenStore) getMarker(ctx context.Context, token, kind string) (*sqlTokenMarker, error) {
+	row := new(sqlTokenMarker)
+	err := s.client.GetDB().NewSelect().Model(row).
+		Where("token = ?", token).
+		Where("kind = ?", kind).
+		Where("expired_at > ?", time.Now()).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+func (s *SqlTokenStore) ClaimRefreshToken(ctx context.Context, refreshToken, sessionKey string, expiredTime time.Time) (bool, error) {
+	res, err := s.client.GetDB().NewInsert().Model(&sqlTokenMarker{
+		Token:      refreshToken,
+		Kind:       markerRefreshUsed,
+		SessionKey: sessionKey,
+		ExpiredAt:  expiredTime,
+	}).
+		Ignore().
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+func (s *SqlTokenStore) GetUsedRefreshToken(ctx context.Context, refreshToken string) (string, error) {
+	row, err := s.getMarker(ctx, refreshToken, markerRefreshUsed)
+	if err != nil || row == nil {
+		return "", err
+	}
+	return row.SessionKey, nil
+}
+
+func (s *SqlTokenStore) MarkRevoked(ctx context.Context, token, sessionKey string, expiredTime time.Time) error {
+	if time.Until(expiredTime) <= 0 {
+		return nil
+	}
+	return s.insertMarker(ctx, token, markerRevoked, sessionKey, expiredTime)
+}
+
+func (s *SqlTokenStore) IsRevoked(ctx context.Context, token string) (bool, error) {
+	row, err := s.getMarker(ctx, token, markerRevoked)
+	return row != nil, err
+}
+
+func (s *SqlTokenStore) CountSessions(ctx context.Context) (int64, error) {
+	total, err := s.client.GetDB().NewSelect().Model((*sqlToken)(nil)).
+		Where("expired_at > ?", time.Now()).
+		Count(ctx)
+	return int64(total), err
+}
@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"callcenter-api/common/cache"
+	"callcenter-api/common/log"
+	"callcenter-api/common/response"
+	"callcenter-api/model"
+	"callcenter-api/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	TENANT_HEADER       = "x-tenant-uuid"
+	AUDIT_IMPERSONATION = "impersonation"
+	tenantCacheTTL      = time.Minute
+	auditInsertTimeout  = 5 * time.Second
+)
+
+var ErrTenantNotFound = errors.New("tenant is not found")
+
+var auditWaitGroup sync.WaitGroup
+
+// ImpersonationConfig limits which superadmins may act on another tenant, by user id
+// or username. Every superadmin may when AllowedUsers is empty.
+type ImpersonationConfig struct {
+	Disabled     bool     `mapstructure:"disabled"`
+	AllowedUsers []string `mapstructure:"allowed_users"`
+}
+
+var impersonation ImpersonationConfig
+
+func SetImpersonation(config ImpersonationConfig) {
+	impersonation = config
+}
+
+type tenant struct {
+	DomainUuid    string `bun:"domain_uuid"`
+	DomainName    string `bun:"domain_name"`
+	DomainEnabled string `bun:"domain_enabled"`
+}
+
+// impersonate switches a superadmin to the tenant of the x-tenant-uuid header and
+// writes the response itself when it refuses. The user keeps its own tenant in
+// OriginalDomainId and OriginalDomainName.
+func impersonate(c *gin.Context, user *GoAuthUser) bool {
+	tenantUuid := c.GetHeader(TENANT_HEADER)
+	if len(tenantUuid) < 1 || user.Level != SUPERADMIN || tenantUuid == user.DomainId {
+		return true
+	}
+	if impersonation.Disabled || !isImpersonationAllowed(user) {
+		log.Errorf("user %s is not allowed to act on tenant %s", user.Id, tenantUuid)
+		c.JSON(response.Forbidden())
+		c.Abort()
+		return false
+	}
+	domain, err := getTenant(c, tenantUuid)
+	if errors.Is(err, ErrTenantNotFound) {
+		log.Errorf("user %s asked for unknown tenant %s", user.Id, tenantUuid)
+		c.JSON(response.BadRequestMsg(TENANT_HEADER + " is not a valid tenant"))
+		c.Abort()
+		return false
+	} else if err != nil {
+		log.Error(err)
+		c.JSON(response.ServiceUnavailableMsg(err.Error()))
+		c.Abort()
+		return false
+	}
+	user.OriginalDomainId = user.DomainId
+	user.OriginalDomainName = user.DomainName
+	user.DomainId = domain.DomainUuid
+	user.DomainName = domain.DomainName
+	return true
+}
+
+func isImpersonationAllowed(user *GoAuthUser) bool {
+	if len(impersonation.AllowedUsers) < 1 {
+		return true
+	}
+	for _, allowed := range impersonation.AllowedUsers {
+		if allowed == user.Id || allowed == user.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func getTenant(ctx context.Context, tenantUuid string) (*tenant, error) {
+	if _, err := uuid.Parse(tenantUuid); err != nil {
+		return nil, ErrTenantNotFound
+	}
+	key := "tenant:" + tenantUuid
+	if cache.MCache != nil {
+		if value, err := cache.MCache.Get(key); err == nil && value != nil {
+			if domain, ok := value.(*tenant); ok {
+				return domain, nil
+			}
+		}
+	}
+	if repository.FusionSqlClient == nil {
+		return nil, errors.New("tenant can not be checked without db")
+	}
+	domain := new(tenant)
+	err := repository.FusionSqlClient.GetDB().NewSelect().
+		TableExpr("v_domains AS d").
+		ColumnExpr("d.domain_uuid, d.domain_name").
+		ColumnExpr("cast(d.domain_enabled as text) as domain_enabled").
+		Where("d.domain_uuid = ?", tenantUuid).
+		Scan(ctx, domain)
+	if err == sql.ErrNoRows {
+		return nil, ErrTenantNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	if !isEnabled(domain.DomainEnabled) {
+		return nil, ErrTenantNotFound
+	}
+	if cache.MCache != nil {
+		if err := cache.MCache.SetTTL(key, domain, tenantCacheTTL); err != nil {
+			log.Error(err)
+		}
+	}
+	return domain, nil
+}
+
+// auditImpersonation records a request made on another tenant once it is answered.
+func auditImpersonation(c *gin.Context, user *GoAuthUser) {
+	auditLog := &model.AuditLog{
+		Id:                 uuid.NewString(),
+		Action:             AUDIT_IMPERSONATION,
+		UserUuid:           user.Id,
+		Username:           user.Name,
+		OriginalDomainUuid: user.OriginalDomainId,
+		DomainUuid:         user.DomainId,
+		Method:             c.Request.Method,
+		Path:               c.Request.URL.Path,
+		IpAddress:          c.ClientIP(),
+		Status:             c.Writer.Status(),
+		CreatedAt:          time.Now(),
+	}
+	log.Infof("audit: user %s from tenant %s acted on tenant %s: %s %s %d", auditLog.UserUuid,
+		auditLog.OriginalDomainUuid, auditLog.DomainUuid, auditLog.Method, auditLog.Path, auditLog.Status)
+	if repository.AuditLogRepo == nil {
+		return
+	}
+	auditWaitGroup.Add(1)
+	go func() {
+		defer auditWaitGroup.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), auditInsertTimeout)
+		defer cancel()
+		if err := repository.AuditLogRepo.Insert(ctx, auditLog); err != nil {
+			log.Error(err)
+		}
+	}()
+}
+
+// WaitAuditLogs waits for the audit logs being inserted, at shutdown before the
+// database is closed.
+func WaitAuditLogs(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		auditWaitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -2,19 +2,20 @@ package auth
 
 import (
 	"callcenter-api/common/log"
+	"callcenter-api/common/response"
 	"callcenter-api/middleware/auth/goauth"
 	"callcenter-api/repository"
 	"context"
-	"crypto/md5"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"errors"
-	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/gin-gonic/gin"
 	"github.com/shaj13/go-guardian/v2/auth"
 	"github.com/shaj13/go-guardian/v2/auth/strategies/basic"
@@ -26,6 +27,13 @@ import (
 )
 
 var cacheObj libcache.Cache
+var apiKeyCacheObj libcache.Cache
+var basicCacheObj libcache.Cache
+
+// basicCacheTTL keeps a verified basic auth password for a short time, hashing it on
+// every request is too expensive with argon2id.
+const basicCacheTTL = 30 * time.Second
+
 var strategy union.Union
 var tokenStrategy auth.Strategy
 
@@ -45,16 +53,103 @@ func NewLocalAuthMiddleware() IAuthMiddleware {
 func SetupGoGuardian() {
 	cacheObj = libcache.FIFO.New(0)
 	cacheObj.SetTTL(time.Minute * 10)
-	basicStrategy := basic.NewCached(validateBasicAuth, cacheObj)
+	apiKeyCacheObj = libcache.FIFO.New(0)
+	apiKeyCacheObj.SetTTL(time.Minute * 10)
+	basicCacheObj = libcache.FIFO.New(0)
+	basicCacheObj.SetTTL(basicCacheTTL)
+	// basic is not cached by the strategy, a cached strategy compares passwords itself
+	// and so skips LoginGuard and the accounts disabled or locked since.
+	basicStrategy := basic.New(validateCachedBasicAuth)
 	tokenStrategy = token.New(validateTokenAuth, cacheObj)
-	strategy = union.New(tokenStrategy, basicStrategy)
+	strategies := []auth.Strategy{&revocableStrategy{Strategy: tokenStrategy, parser: token.AuthorizationParser(string(token.Bearer))}, newApiKeyStrategy(), basicStrategy}
+	if len(clientCertIdentities) > 0 {
+		strategies = append(strategies, clientCertStrategy{})
+	}
+	strategy = union.New(strategies...)
+}
+
+// revocableStrategy refuses tokens revoked through goauth before the wrapped
+// strategy gets a chance to answer them from cacheObj.
+type revocableStrategy struct {
+	auth.Strategy
+	parser token.Parser
+}
+
+func (s *revocableStrategy) Authenticate(ctx context.Context, r *http.Request) (auth.Info, error) {
+	tokenString, err := s.parser.Token(r)
+	if err != nil {
+		return nil, err
+	}
+	if goauth.GoAuthClient != nil {
+		revoked, err := goauth.GoAuthClient.IsRevoked(ctx, tokenString)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			_ = auth.Revoke(s.Strategy, tokenString)
+			return nil, errors.New("token is revoked")
+		}
+	}
+	return s.Strategy.Authenticate(ctx, r)
+}
+
+// RevokeToken ends the session of token and drops it from the local strategy cache.
+func RevokeToken(ctx context.Context, tokenString string) error {
+	if err := goauth.GoAuthClient.Revoke(ctx, tokenString); err != nil {
+		return err
+	}
+	if tokenStrategy != nil {
+		_ = auth.Revoke(tokenStrategy, tokenString)
+	}
+	return nil
+}
+
+// IsUserInDomain reports whether userId belongs to the domain domainId.
+func IsUserInDomain(ctx context.Context, userId, domainId string) (bool, error) {
+	count, err := repository.FusionSqlClient.GetDB().NewSelect().
+		Model((*UserAuth)(nil)).
+		Where("u.user_uuid = ?", userId).
+		Where("u.domain_uuid = ?", domainId).
+		Count(ctx)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetUserLevelInDomain returns the level of userId, who must belong to domainId
+// unless domainId is empty.
+func GetUserLevelInDomain(ctx context.Context, userId, domainId string) (string, error) {
+	user := new(UserAuth)
+	query := repository.FusionSqlClient.GetDB().NewSelect().
+		Model(user).
+		ColumnExpr("u.level").
+		Where("u.user_uuid = ?", userId)
+	if len(domainId) > 0 {
+		query = query.Where("u.domain_uuid = ?", domainId)
+	}
+	err := query.Limit(1).Scan(ctx)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return user.Level, nil
 }
 
 func (auth *LocalAuthMiddleware) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		_, user, err := strategy.AuthenticateRequest(c.Request)
-		if err != nil {
-			log.Error("invalid credentials")
+		SetClientIP(c)
+		user, err := AuthenticateRequest(c.Request)
+		if locked, ok := AsLockedError(err); ok {
+			log.Error(err)
+			retryAfter := int(math.Ceil(locked.RetryAfter.Seconds()))
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(response.Locked(retryAfter))
+			c.Abort()
+			return
+		} else if err != nil {
+			log.Error("invalid credentials: ", err)
 			c.JSON(
 				http.StatusUnauthorized,
 				map[string]interface{}{
@@ -79,6 +174,7 @@ type UserAuth struct {
 	Salt          string `json:"salt" bun:"salt"`
 	ApiKey        string `json:"api_key" bun:"api_key"`
 	UserEnabled   string `json:"user_enabled" bun:"user_enabled"`
+	DomainEnabled string `json:"domain_enabled" bun:"domain_enabled,scanonly"`
 	Level         string `json:"level" bun:"level"`
 }
 
@@ -86,8 +182,9 @@ func findUserByUsername(ctx context.Context, domainName string, username string)
 	user := new(UserAuth)
 	err := repository.FusionSqlClient.GetDB().NewSelect().
 		Model(user).
-		ColumnExpr("u.username, u.user_uuid, u.domain_uuid, u.api_key, u.user_enabled, u.password, u.salt, u.level").
-		ColumnExpr("d.domain_name").
+		ColumnExpr("u.username, u.user_uuid, u.domain_uuid, u.api_key, u.password, u.salt, u.level").
+		ColumnExpr("cast(u.user_enabled as text) as user_enabled").
+		ColumnExpr("d.domain_name, cast(d.domain_enabled as text) as domain_enabled").
 		Join("inner join v_domains d on u.domain_uuid = d.domain_uuid").
 		Where("u.username = ?", username).
 		Where("d.domain_name = ?", domainName).
@@ -109,61 +206,184 @@ func validateBasicAuth(ctx context.Context, r *http.Request, username, password
 	var domainName string
 	username = userDomain[0]
 	domainName = userDomain[1]
+	account := loginAccount(username, domainName)
+	ip := clientIP(r)
+	if LoginGuard != nil {
+		if err := LoginGuard.Check(ctx, account, ip); err != nil {
+			if _, ok := AsLockedError(err); ok {
+				log.Errorf("basic auth refused for %s from %s: %v", account, ip, err)
+				return nil, err
+			}
+			log.Error(err)
+		}
+	}
 	user, err := findUserByUsername(ctx, domainName, username)
 	if err != nil {
 		log.Error(err)
 		return nil, errors.New("invalid credentials")
 	} else if user == nil {
+		VerifyUserPassword(ctx, nil, password)
 		log.Error("basic auth not found username")
+		failLogin(ctx, account, ip)
 		return nil, errors.New("invalid credentials")
 	}
-	passCurrent := user.Password
-	hash := md5.New()
-	salt := user.Salt
-	tmp := salt + password
-	_, err = hash.Write([]byte(tmp))
+	if !VerifyUserPassword(ctx, user, password) {
+		failLogin(ctx, account, ip)
+		return nil, errors.New("username or password is not valid")
+	}
+	if err := checkUserAuthEnabled(user); err != nil {
+		log.Errorf("basic auth refused for user %s: %v", user.UserUuid, err)
+		return nil, err
+	}
+	// only an enabled account clears its failures, a disabled one stays locked
+	if LoginGuard != nil {
+		if err := LoginGuard.Succeed(ctx, account, ip); err != nil {
+			log.Error(err)
+		}
+	}
+	return NewGoAuthUser(user.Username, user.UserUuid, nil, nil, user.DomainUuid, user.DomainName, user.Level, []string{goauth.SCOPE_ALL}), nil
+}
+
+// validateCachedBasicAuth answers basic auth from basicCacheObj when the same username
+// and password were verified in the last basicCacheTTL. LoginGuard is still checked on
+// every request, AuthenticateRequest checks that the account is enabled.
+func validateCachedBasicAuth(ctx context.Context, r *http.Request, username, password string) (auth.Info, error) {
+	sum := sha256.Sum256([]byte(username + ":" + password))
+	key := hex.EncodeToString(sum[:])
+	if value, ok := basicCacheObj.Load(key); ok {
+		if userDomain := strings.Split(username, "@"); LoginGuard != nil && len(userDomain) == 2 {
+			if err := LoginGuard.Check(ctx, loginAccount(userDomain[0], userDomain[1]), clientIP(r)); err != nil {
+				if _, ok := AsLockedError(err); ok {
+					basicCacheObj.Delete(key)
+					return nil, err
+				}
+				log.Error(err)
+			}
+		}
+		if info, ok := value.(auth.Info); ok {
+			return info, nil
+		}
+	}
+	info, err := validateBasicAuthStrategy(ctx, r, username, password)
+	if err != nil {
+		return nil, err
+	}
+	basicCacheObj.Store(key, info)
+	return info, nil
+}
+
+// failLogin records a failed login and slows down its answer.
+func failLogin(ctx context.Context, account, ip string) {
+	if LoginGuard == nil {
+		return
+	}
+	delay, err := LoginGuard.Fail(ctx, account, ip)
 	if err != nil {
 		log.Error(err)
-		return nil, errors.New("invalid credentials")
+		return
 	}
-	passEncrypted := string(hex.EncodeToString(hash.Sum(nil)))
-	if passEncrypted != passCurrent {
-		return nil, errors.New("username or password is not valid")
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
 	}
-	return NewGoAuthUser(user.Username, user.UserUuid, nil, nil, user.DomainUuid, user.DomainName, user.Level, nil), nil
+}
+
+// ValidateUserPassword authenticates a username of the form user@domain and its password.
+func ValidateUserPassword(ctx context.Context, r *http.Request, username, password string) (*GoAuthUser, error) {
+	info, err := validateBasicAuth(ctx, r, username, password)
+	countLogin(LOGIN_PASSWORD, err)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := info.(*GoAuthUser)
+	if !ok {
+		return nil, errors.New("invalid credentials")
+	}
+	return user, nil
 }
 
 func validateTokenAuth(ctx context.Context, r *http.Request, tokenString string) (auth.Info, time.Time, error) {
-	if tokenString == SECRET_TOKEN {
-		id := "2273f762-7ae6-4a0e-a09d-6d5a3c961a50"
-		name := "portal"
-		domainId := "2273f762-7ae6-4a0e-a09d-6d5a3c961a50"
-		domainName := "2273f762-7ae6-4a0e-a09d-6d5a3c961a50"
-		level := "superadmin"
-		user := NewGoAuthUser(name, id, nil, nil, domainId, domainName, level, nil)
+	// service keys are also accepted as bearer tokens
+	if user := findServiceKey(tokenString); user != nil {
 		return user, time.Now(), nil
 	}
-	client, err := goauth.GoAuthClient.CheckTokenInRedis(ctx, tokenString)
+	user, _, err := authenticateToken(ctx, tokenString)
 	if err != nil {
 		return nil, time.Time{}, err
 	}
-	token, err := jwt.Parse(client.JWT, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte("secret"), nil
-	})
+	return user, time.Now(), nil
+}
+
+func authenticateToken(ctx context.Context, tokenString string) (*GoAuthUser, goauth.AuthClient, error) {
+	client, err := goauth.GoAuthClient.CheckTokenInRedis(ctx, tokenString)
 	if err != nil {
-		return nil, time.Time{}, err
+		return nil, client, err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		id := client.UserId
-		name, _ := claims["username"].(string)
-		domainId, _ := claims["domain_uuid"].(string)
-		domainName, _ := claims["domain_name"].(string)
-		level, _ := claims["level"].(string)
-		user := NewGoAuthUser(name, id, nil, nil, domainId, domainName, level, nil)
-		return user, time.Now(), nil
+	claims, err := goauth.ParseJWT(client.JWT)
+	if err != nil {
+		return nil, client, err
+	}
+	name, _ := claims["username"].(string)
+	domainId, _ := claims["domain_uuid"].(string)
+	domainName, _ := claims["domain_name"].(string)
+	level, _ := claims["level"].(string)
+	scopes := client.Scopes
+	if len(scopes) < 1 {
+		scope, _ := claims["scope"].(string)
+		scopes = goauth.ParseScope(scope)
+	}
+	if len(scopes) < 1 && !client.Registered {
+		// legacy tokens issued without scopes are not narrowed, a registered client
+		// without scopes has none
+		scopes = []string{goauth.SCOPE_ALL}
+	}
+	user := &GoAuthUser{
+		Id:         client.UserId,
+		Name:       name,
+		DomainId:   domainId,
+		DomainName: domainName,
+		Level:      level,
+		Scopes:     scopes,
+	}
+	if client.UserId == "" || client.UserId == client.ClienId {
+		user.GetExtensions().Set(EXT_SUBJECT, SUBJECT_CLIENT)
+	}
+	return user, client, nil
+}
+
+// IntrospectToken returns the user and the session of an access token issued by goauth.
+func IntrospectToken(ctx context.Context, tokenString string) (*GoAuthUser, goauth.AuthClient, error) {
+	revoked, err := goauth.GoAuthClient.IsRevoked(ctx, tokenString)
+	if err != nil {
+		return nil, goauth.AuthClient{}, err
+	} else if revoked {
+		return nil, goauth.AuthClient{}, errors.New("token is revoked")
+	}
+	user, client, err := authenticateToken(ctx, tokenString)
+	if err != nil {
+		return nil, client, err
 	}
-	return nil, time.Time{}, errors.New("invalid token")
+	if err := CheckAccountEnabled(ctx, user); err != nil {
+		log.Errorf("introspection refused for user %s: %v", user.Id, err)
+		return nil, client, err
+	}
+	return user, client, nil
+}
+
+// AuthenticateRequest authenticates r with the local strategies, the same way
+// LocalAuthMiddleware does.
+func AuthenticateRequest(r *http.Request) (*GoAuthUser, error) {
+	_, info, err := strategy.AuthenticateRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := info.(*GoAuthUser)
+	if !ok {
+		return nil, errors.New("invalid credentials")
+	}
+	if err := CheckAccountEnabled(r.Context(), user); err != nil {
+		log.Errorf("authentication refused for user %s: %v", user.Id, err)
+		return nil, err
+	}
+	return user, nil
 }
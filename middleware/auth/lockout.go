@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"callcenter-api/repository"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/shaj13/go-guardian/v2/auth/strategies/union"
+)
+
+// LockedError is returned while an account or a client ip is locked.
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed attempts, locked for %s", e.RetryAfter.Round(time.Second))
+}
+
+// AsLockedError finds a LockedError in err, including the errors of a union strategy.
+func AsLockedError(err error) (*LockedError, bool) {
+	var locked *LockedError
+	if errors.As(err, &locked) {
+		return locked, true
+	}
+	if errs, ok := err.(union.MultiError); ok {
+		for _, err := range errs {
+			if locked, ok := AsLockedError(err); ok {
+				return locked, true
+			}
+		}
+	}
+	return nil, false
+}
+
+type LockoutConfig struct {
+	MaxAttempts   int `mapstructure:"max_attempts"`
+	IpMaxAttempts int `mapstructure:"ip_max_attempts"`
+	// Window is how long failed attempts are counted, in seconds.
+	Window int `mapstructure:"window"`
+	// LockDuration is how long an account or ip stays locked, in seconds.
+	LockDuration int `mapstructure:"lock_duration"`
+	DelayStepMs  int `mapstructure:"delay_step_ms"`
+	MaxDelayMs   int `mapstructure:"max_delay_ms"`
+}
+
+type LoginStatus struct {
+	Failures   int64 `json:"failures"`
+	Locked     bool  `json:"locked"`
+	RetryAfter int   `json:"retry_after"`
+}
+
+// ILoginGuard counts failed logins per account, which is username@domain, and per
+// client ip.
+type ILoginGuard interface {
+	Check(ctx context.Context, account, ip string) error
+	// Fail records a failed login and returns how long to wait before answering it.
+	Fail(ctx context.Context, account, ip string) (time.Duration, error)
+	Succeed(ctx context.Context, account, ip string) error
+	Status(ctx context.Context, account string) (LoginStatus, error)
+	Unlock(ctx context.Context, account string) error
+	UnlockIp(ctx context.Context, ip string) error
+}
+
+// LoginGuard protects password logins when set.
+var LoginGuard ILoginGuard
+
+type RedisLoginGuard struct {
+	client *redis.Client
+	config LockoutConfig
+}
+
+func NewRedisLoginGuard(client *redis.Client, config LockoutConfig) ILoginGuard {
+	if config.MaxAttempts == 0 {
+		config.MaxAttempts = 5
+	}
+	if config.IpMaxAttempts == 0 {
+		config.IpMaxAttempts = 20
+	}
+	if config.Window == 0 {
+		config.Window = 900
+	}
+	if config.LockDuration == 0 {
+		config.LockDuration = 900
+	}
+	if config.DelayStepMs == 0 {
+		config.DelayStepMs = 200
+	}
+	if config.MaxDelayMs == 0 {
+		config.MaxDelayMs = 3000
+	}
+	return &RedisLoginGuard{
+		client: client,
+		config: config,
+	}
+}
+
+func failKey(kind, value string) string {
+	return "login_fail:" + kind + ":" + value
+}
+
+func lockKey(kind, value string) string {
+	return "login_lock:" + kind + ":" + value
+}
+
+func (g *RedisLoginGuard) Check(ctx context.Context, account, ip string) error {
+	for _, key := range []string{lockKey("user", account), lockKey("ip", ip)} {
+		ttl, err := g.client.TTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if ttl > 0 {
+			return &LockedError{RetryAfter: ttl}
+		}
+	}
+	return nil
+}
+
+func (g *RedisLoginGuard) Fail(ctx context.Context, account, ip string) (time.Duration, error) {
+	window := time.Duration(g.config.Window) * time.Second
+	accountFailures, err := g.incr(ctx, failKey("user", account), window)
+	if err != nil {
+		return 0, err
+	}
+	ipFailures, err := g.incr(ctx, failKey("ip", ip), window)
+	if err != nil {
+		return 0, err
+	}
+	lockDuration := time.Duration(g.config.LockDuration) * time.Second
+	if accountFailures >= int64(g.config.MaxAttempts) {
+		if err := g.lock(ctx, "user", account, lockDuration); err != nil {
+			return 0, err
+		}
+	}
+	if ipFailures >= int64(g.config.IpMaxAttempts) {
+		if err := g.lock(ctx, "ip", ip, lockDuration); err != nil {
+			return 0, err
+		}
+	}
+	failures := accountFailures
+	if ipFailures > failures {
+		failures = ipFailures
+	}
+	delay := float64(g.config.DelayStepMs) * math.Pow(2, float64(failures-1))
+	delay = math.Min(delay, float64(g.config.MaxDelayMs))
+	return time.Duration(delay) * time.Millisecond, nil
+}
+
+func (g *RedisLoginGuard) incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	count, err := g.client.Incr(ctx, key).Result()
+	if err != nil {
+		return 0, err
+	}
+	if count == 1 {
+		if err := g.client.Expire(ctx, key, window).Err(); err != nil {
+			return 0, err
+		}
+	}
+	return count, nil
+}
+
+func (g *RedisLoginGuard) lock(ctx context.Context, kind, value string, duration time.Duration) error {
+	_, err := g.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, lockKey(kind, value), time.Now().Unix(), duration)
+		pipe.Del(ctx, failKey(kind, value))
+		return nil
+	})
+	return err
+}
+
+func (g *RedisLoginGuard) Succeed(ctx context.Context, account, ip string) error {
+	return g.client.Del(ctx, failKey("user", account)).Err()
+}
+
+func (g *RedisLoginGuard) Status(ctx context.Context, account string) (LoginStatus, error) {
+	status := LoginStatus{}
+	failures, err := g.client.Get(ctx, failKey("user", account)).Int64()
+	if err != nil && err != redis.Nil {
+		return status, err
+	}
+	status.Failures = failures
+	ttl, err := g.client.TTL(ctx, lockKey("user", account)).Result()
+	if err != nil {
+		return status, err
+	}
+	if ttl > 0 {
+		status.Locked = true
+		status.RetryAfter = int(math.Ceil(ttl.Seconds()))
+	}
+	return status, nil
+}
+
+func (g *RedisLoginGuard) Unlock(ctx context.Context, account string) error {
+	return g.client.Del(ctx, lockKey("user", account), failKey("user", account)).Err()
+}
+
+func (g *RedisLoginGuard) UnlockIp(ctx context.Context, ip string) error {
+	return g.client.Del(ctx, lockKey("ip", ip), failKey("ip", ip)).Err()
+}
+
+// loginAccount is the account name that failed logins are counted for.
+func loginAccount(username, domainName string) string {
+	return strings.ToLower(username + "@" + domainName)
+}
+
+type clientIPKey struct{}
+
+// SetClientIP keeps the client ip resolved by gin in the request context, so that the
+// strategies count failed logins for it rather than for a proxy. gin only reads the
+// forwarding headers of the trusted proxies of api.ServerConfig.
+func SetClientIP(c *gin.Context) {
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), clientIPKey{}, c.ClientIP()))
+}
+
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok && len(ip) > 0 {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// GetUserAccount returns the account name of userId that failed logins are counted for.
+func GetUserAccount(ctx context.Context, userId string) (string, error) {
+	user := new(UserAuth)
+	err := repository.FusionSqlClient.GetDB().NewSelect().
+		Model(user).
+		ColumnExpr("u.username").
+		ColumnExpr("d.domain_name").
+		Join("inner join v_domains d on u.domain_uuid = d.domain_uuid").
+		Where("u.user_uuid = ?", userId).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return loginAccount(user.Username, user.DomainName), nil
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	LOGIN_BASIC    = "basic"
+	LOGIN_PASSWORD = "password"
+	LOGIN_MFA      = "mfa"
+)
+
+var loginsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "auth_logins_total",
+	Help: "Logins checked by the local strategies, by method and result.",
+}, []string{"method", "result"})
+
+// countLogin records the outcome of a login by method, a locked account or ip counts
+// apart from wrong credentials.
+func countLogin(method string, err error) {
+	result := "success"
+	if _, ok := AsLockedError(err); ok {
+		result = "locked"
+	} else if err != nil {
+		result = "failure"
+	}
+	loginsTotal.WithLabelValues(method, result).Inc()
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"callcenter-api/common/log"
+	"callcenter-api/middleware/auth/goauth"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/shaj13/go-guardian/v2/auth"
+)
+
+const (
+	MFA_TOKEN_HEADER = "X-MFA-Token"
+	// EXT_MFA_PENDING marks a user authenticated by a mfa_pending token, who may only
+	// enroll its second factor.
+	EXT_MFA_PENDING = "mfa_pending"
+)
+
+var (
+	ErrMfaRequired    = errors.New("second factor is required, log in through /oauth/token")
+	ErrMfaInvalidCode = errors.New("second factor code is not valid")
+)
+
+// IMfa checks the second factor of local users.
+type IMfa interface {
+	// Check reports whether userId has to pass a second factor, because it enrolled
+	// or because of its level, and whether it enrolled.
+	Check(ctx context.Context, userId, level string) (bool, bool, error)
+	// Verify checks a TOTP code or a recovery code of userId.
+	Verify(ctx context.Context, userId, code string) error
+	PendingExpiredIn() int
+}
+
+// Mfa enables the second factor when set.
+var Mfa IMfa
+
+// validateBasicAuthStrategy is validateBasicAuth for the basic strategy, which can
+// not ask for a second factor and so refuses users that need one.
+func validateBasicAuthStrategy(ctx context.Context, r *http.Request, username, password string) (auth.Info, error) {
+	info, err := validateBasicAuth(ctx, r, username, password)
+	if err != nil || Mfa == nil {
+		countLogin(LOGIN_BASIC, err)
+		return info, err
+	}
+	required, _, err := Mfa.Check(ctx, info.GetID(), info.(*GoAuthUser).Level)
+	if err != nil {
+		log.Error(err)
+		err = errors.New("invalid credentials")
+	} else if required {
+		log.Errorf("basic auth refused for user %s: %v", info.GetID(), ErrMfaRequired)
+		err = ErrMfaRequired
+	}
+	countLogin(LOGIN_BASIC, err)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// MfaPendingMiddleware authenticates with the mfa_pending token of the X-MFA-Token
+// header when there is one, so that a user can enroll before its first full login,
+// and with AuthMiddleware otherwise.
+func MfaPendingMiddleware() gin.HandlerFunc {
+	authenticate := AuthMiddleware()
+	return func(c *gin.Context) {
+		token := strings.TrimSpace(c.GetHeader(MFA_TOKEN_HEADER))
+		if len(token) < 1 {
+			authenticate(c)
+			return
+		}
+		client, err := goauth.ParseMfaPendingToken(token)
+		if err != nil {
+			log.Error(err)
+			c.JSON(
+				http.StatusUnauthorized,
+				map[string]interface{}{
+					"error": http.StatusText(http.StatusUnauthorized),
+				},
+			)
+			c.Abort()
+			return
+		}
+		name, _ := client.UserData["username"].(string)
+		domainId, _ := client.UserData["domain_uuid"].(string)
+		domainName, _ := client.UserData["domain_name"].(string)
+		level, _ := client.UserData["level"].(string)
+		user := &GoAuthUser{
+			Id:         client.UserId,
+			Name:       name,
+			DomainId:   domainId,
+			DomainName: domainName,
+			Level:      level,
+			Extensions: auth.Extensions{EXT_MFA_PENDING: []string{"true"}},
+		}
+		c.Set("user", user)
+	}
+}
+
+// IsMfaPending reports whether the user of the request only passed its password.
+func IsMfaPending(c *gin.Context) bool {
+	user, ok := GetUser(c)
+	return ok && len(user.GetExtensions().Get(EXT_MFA_PENDING)) > 0
+}
+
+// VerifyLoginMfa checks the second factor of the login resumed from an mfa_pending
+// token. Wrong codes count as failed logins of the account.
+func VerifyLoginMfa(ctx context.Context, r *http.Request, client goauth.AuthClient, code string) error {
+	if Mfa == nil {
+		return ErrMfaInvalidCode
+	}
+	username, _ := client.UserData["username"].(string)
+	domainName, _ := client.UserData["domain_name"].(string)
+	account := loginAccount(username, domainName)
+	ip := clientIP(r)
+	if LoginGuard != nil {
+		if err := LoginGuard.Check(ctx, account, ip); err != nil {
+			if _, ok := AsLockedError(err); ok {
+				log.Errorf("second factor refused for %s from %s: %v", account, ip, err)
+				countLogin(LOGIN_MFA, err)
+				return err
+			}
+			log.Error(err)
+		}
+	}
+	if err := Mfa.Verify(ctx, client.UserId, code); errors.Is(err, ErrMfaInvalidCode) {
+		log.Errorf("second factor refused for user %s: %v", client.UserId, err)
+		failLogin(ctx, account, ip)
+		countLogin(LOGIN_MFA, err)
+		return err
+	} else if err != nil {
+		return err
+	}
+	if LoginGuard != nil {
+		if err := LoginGuard.Succeed(ctx, account, ip); err != nil {
+			log.Error(err)
+		}
+	}
+	countLogin(LOGIN_MFA, nil)
+	return nil
+}
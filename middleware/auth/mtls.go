@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"callcenter-api/common/log"
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+
+	"github.com/shaj13/go-guardian/v2/auth"
+)
+
+// ClientCertIdentity maps a client certificate, verified against the client CA pool
+// of the server, to a service. The certificate matches by Subject, its full
+// distinguished name or its common name, or by one of SANs, its DNS names, URIs,
+// emails and IP addresses. An identity is granted its Scopes only, which must not be
+// empty.
+type ClientCertIdentity struct {
+	Id         string   `mapstructure:"id"`
+	Name       string   `mapstructure:"name"`
+	Subject    string   `mapstructure:"subject"`
+	SANs       []string `mapstructure:"sans"`
+	Level      string   `mapstructure:"level"`
+	DomainId   string   `mapstructure:"domain_id"`
+	DomainName string   `mapstructure:"domain_name"`
+	Scopes     []string `mapstructure:"scopes"`
+}
+
+var clientCertIdentities []ClientCertIdentity
+
+// SetClientCertIdentities replaces the configured client certificate identities.
+func SetClientCertIdentities(identities []ClientCertIdentity) error {
+	result := make([]ClientCertIdentity, 0, len(identities))
+	for _, identity := range identities {
+		if len(identity.Id) < 1 {
+			return errors.New("client cert " + identity.Name + " has no id")
+		}
+		if len(identity.Subject) < 1 && len(identity.SANs) < 1 {
+			return errors.New("client cert " + identity.Name + " has neither subject nor sans")
+		}
+		if LevelRank(identity.Level) < 1 {
+			return errors.New("client cert " + identity.Name + " has invalid level")
+		}
+		if len(identity.Scopes) < 1 {
+			return errors.New("client cert " + identity.Name + " has no scopes")
+		}
+		result = append(result, identity)
+	}
+	clientCertIdentities = result
+	return nil
+}
+
+func (identity *ClientCertIdentity) match(cert *x509.Certificate) bool {
+	if len(identity.Subject) > 0 && (identity.Subject == cert.Subject.String() || identity.Subject == cert.Subject.CommonName) {
+		return true
+	}
+	for _, san := range identity.SANs {
+		for _, name := range cert.DNSNames {
+			if san == name {
+				return true
+			}
+		}
+		for _, uri := range cert.URIs {
+			if san == uri.String() {
+				return true
+			}
+		}
+		for _, email := range cert.EmailAddresses {
+			if san == email {
+				return true
+			}
+		}
+		for _, ip := range cert.IPAddresses {
+			if san == ip.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findClientCertIdentity returns the user of the first identity matching cert.
+func findClientCertIdentity(cert *x509.Certificate) *GoAuthUser {
+	for i := range clientCertIdentities {
+		identity := &clientCertIdentities[i]
+		if !identity.match(cert) {
+			continue
+		}
+		return &GoAuthUser{
+			Id:         identity.Id,
+			Name:       identity.Name,
+			DomainId:   identity.DomainId,
+			DomainName: identity.DomainName,
+			Level:      identity.Level,
+			Scopes:     identity.Scopes,
+			Extensions: auth.Extensions{EXT_SUBJECT: []string{SUBJECT_SERVICE}},
+		}
+	}
+	return nil
+}
+
+// clientCertStrategy authenticates with the client certificate of the TLS connection.
+// Only certificates verified by the server are considered, so it needs the server to
+// serve TLS with a client CA pool.
+type clientCertStrategy struct{}
+
+func (clientCertStrategy) Authenticate(ctx context.Context, r *http.Request) (auth.Info, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) < 1 || len(r.TLS.VerifiedChains[0]) < 1 {
+		return nil, errors.New("no verified client certificate")
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	user := findClientCertIdentity(cert)
+	if user == nil {
+		log.Errorf("client certificate %s is not mapped to an identity", cert.Subject.String())
+		return nil, errors.New("client certificate is not allowed")
+	}
+	return user, nil
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestClientCertIdentityMatch(t *testing.T) {
+	uri, _ := url.Parse("spiffe://example.com/pbx")
+	cert := &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "pbx-events.example.com", Organization: []string{"Example"}},
+		DNSNames:       []string{"pbx-events.example.com"},
+		URIs:           []*url.URL{uri},
+		EmailAddresses: []string{"pbx@example.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.5")},
+	}
+	tests := []struct {
+		name     string
+		identity ClientCertIdentity
+		want     bool
+	}{
+		{"common name", ClientCertIdentity{Subject: "pbx-events.example.com"}, true},
+		{"distinguished name", ClientCertIdentity{Subject: cert.Subject.String()}, true},
+		{"other subject", ClientCertIdentity{Subject: "other.example.com"}, false},
+		{"dns san", ClientCertIdentity{SANs: []string{"other.example.com", "pbx-events.example.com"}}, true},
+		{"uri san", ClientCertIdentity{SANs: []string{"spiffe://example.com/pbx"}}, true},
+		{"email san", ClientCertIdentity{SANs: []string{"pbx@example.com"}}, true},
+		{"ip san", ClientCertIdentity{SANs: []string{"10.0.0.5"}}, true},
+		{"other sans", ClientCertIdentity{SANs: []string{"10.0.0.6", "*.example.com"}}, false},
+		{"nothing to match", ClientCertIdentity{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.identity.match(cert); got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetClientCertIdentities(t *testing.T) {
+	valid := ClientCertIdentity{Id: "pbx", Name: "pbx", Subject: "pbx-events.example.com", Level: MANAGER, Scopes: []string{"events:write"}}
+	tests := []struct {
+		name    string
+		edit    func(identity *ClientCertIdentity)
+		wantErr bool
+	}{
+		{"valid", func(identity *ClientCertIdentity) {}, false},
+		{"no id", func(identity *ClientCertIdentity) { identity.Id = "" }, true},
+		{"nothing to match", func(identity *ClientCertIdentity) { identity.Subject = "" }, true},
+		{"invalid level", func(identity *ClientCertIdentity) { identity.Level = "root" }, true},
+		{"no scopes", func(identity *ClientCertIdentity) { identity.Scopes = nil }, true},
+	}
+	defer SetClientCertIdentities(nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity := valid
+			tt.edit(&identity)
+			if err := SetClientCertIdentities([]ClientCertIdentity{identity}); (err != nil) != tt.wantErr {
+				t.Errorf("SetClientCertIdentities error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
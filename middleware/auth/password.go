@@ -0,0 +1,241 @@
+package auth
+
+import (
+	"callcenter-api/common/log"
+	"callcenter-api/repository"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PASSWORD_BCRYPT   = "bcrypt"
+	PASSWORD_ARGON2ID = "argon2id"
+)
+
+// IPasswordHasher hashes new passwords and verifies the hashes it produced.
+type IPasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(hash, password string) (bool, error)
+	// IsCurrent reports whether hash was produced with the current settings.
+	IsCurrent(hash string) bool
+}
+
+type PasswordConfig struct {
+	Algorithm     string `mapstructure:"algorithm"`
+	BcryptCost    int    `mapstructure:"bcrypt_cost"`
+	Argon2Time    uint32 `mapstructure:"argon2_time"`
+	Argon2Memory  uint32 `mapstructure:"argon2_memory"`
+	Argon2Threads uint8  `mapstructure:"argon2_threads"`
+}
+
+var PasswordHasher IPasswordHasher = NewBcryptHasher(bcrypt.DefaultCost)
+
+// NewPasswordHasher returns the hasher of config, bcrypt when no algorithm is set.
+func NewPasswordHasher(config PasswordConfig) (IPasswordHasher, error) {
+	switch config.Algorithm {
+	case "", PASSWORD_BCRYPT:
+		cost := config.BcryptCost
+		if cost == 0 {
+			cost = bcrypt.DefaultCost
+		}
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return nil, errors.New("bcrypt_cost is invalid")
+		}
+		return NewBcryptHasher(cost), nil
+	case PASSWORD_ARGON2ID:
+		return NewArgon2idHasher(config.Argon2Time, config.Argon2Memory, config.Argon2Threads), nil
+	default:
+		return nil, errors.New("password algorithm " + config.Algorithm + " is not supported")
+	}
+}
+
+type BcryptHasher struct {
+	cost int
+}
+
+func NewBcryptHasher(cost int) IPasswordHasher {
+	return &BcryptHasher{cost: cost}
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	return string(hash), err
+}
+
+func (h *BcryptHasher) Verify(hash, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) IsCurrent(hash string) bool {
+	if !isBcryptHash(hash) {
+		return false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost == h.cost
+}
+
+// Argon2idHasher stores hashes in the PHC string format,
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>.
+type Argon2idHasher struct {
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+func NewArgon2idHasher(time, memory uint32, threads uint8) IPasswordHasher {
+	if time == 0 {
+		time = 3
+	}
+	if memory == 0 {
+		memory = 64 * 1024
+	}
+	if threads == 0 {
+		threads = 2
+	}
+	return &Argon2idHasher{time: time, memory: memory, threads: threads}
+}
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.time, h.memory, h.threads, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(hash, password string) (bool, error) {
+	params, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.time, params.memory, params.threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) IsCurrent(hash string) bool {
+	params, _, _, err := parseArgon2idHash(hash)
+	return err == nil && *params == *h
+}
+
+func parseArgon2idHash(hash string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != PASSWORD_ARGON2ID {
+		return nil, nil, nil, errors.New("hash is not argon2id")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, errors.New("argon2id version is not supported")
+	}
+	params := &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return nil, nil, nil, err
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return params, salt, key, nil
+}
+
+func isBcryptHash(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+// verifyPasswordHash checks password against a bcrypt, argon2id or legacy md5(salt +
+// password) hash, whatever hasher is configured.
+func verifyPasswordHash(hash, salt, password string) (bool, error) {
+	switch {
+	case isBcryptHash(hash):
+		return (&BcryptHasher{}).Verify(hash, password)
+	case strings.HasPrefix(hash, "$"+PASSWORD_ARGON2ID+"$"):
+		return (&Argon2idHasher{}).Verify(hash, password)
+	default:
+		sum := md5.Sum([]byte(salt + password))
+		encrypted := hex.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(encrypted), []byte(strings.ToLower(hash))) == 1, nil
+	}
+}
+
+// dummyHash is verified when a user is not found, so that the response takes as long
+// as for a wrong password. It is made by the current PasswordHasher, with its settings.
+var dummyHash struct {
+	sync.Mutex
+	hasher IPasswordHasher
+	hash   string
+}
+
+func dummyPasswordHash() string {
+	dummyHash.Lock()
+	defer dummyHash.Unlock()
+	if dummyHash.hasher != PasswordHasher {
+		hash, err := PasswordHasher.Hash("dummy password")
+		if err != nil {
+			log.Error(err)
+		}
+		dummyHash.hasher = PasswordHasher
+		dummyHash.hash = hash
+	}
+	return dummyHash.hash
+}
+
+// VerifyUserPassword checks the password of user and, when it is stored with a legacy
+// or outdated hash, stores it again with PasswordHasher. A nil user is never verified.
+func VerifyUserPassword(ctx context.Context, user *UserAuth, password string) bool {
+	if user == nil {
+		_, _ = verifyPasswordHash(dummyPasswordHash(), "", password)
+		return false
+	}
+	ok, err := verifyPasswordHash(user.Password, user.Salt, password)
+	if err != nil {
+		log.Error(err)
+		return false
+	} else if !ok {
+		return false
+	}
+	if !PasswordHasher.IsCurrent(user.Password) {
+		if err := upgradePasswordHash(ctx, user, password); err != nil {
+			log.Errorf("upgrade password hash of user %s failed: %v", user.UserUuid, err)
+		}
+	}
+	return true
+}
+
+func upgradePasswordHash(ctx context.Context, user *UserAuth, password string) error {
+	hash, err := PasswordHasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	_, err = repository.FusionSqlClient.GetDB().NewUpdate().
+		Model((*UserAuth)(nil)).
+		Set("password = ?", hash).
+		Set("salt = NULL").
+		Where("user_uuid = ?", user.UserUuid).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	user.Salt = ""
+	return nil
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func legacyHash(salt, password string) string {
+	sum := md5.Sum([]byte(salt + password))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPasswordHashers(t *testing.T) {
+	hashers := []struct {
+		name   string
+		hasher IPasswordHasher
+	}{
+		{PASSWORD_BCRYPT, NewBcryptHasher(bcrypt.MinCost)},
+		{PASSWORD_ARGON2ID, NewArgon2idHasher(1, 1024, 1)},
+	}
+	for _, tt := range hashers {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hasher.Hash("secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := tt.hasher.Verify(hash, "secret"); err != nil || !ok {
+				t.Errorf("Verify(right password) = %v, %v", ok, err)
+			}
+			if ok, err := tt.hasher.Verify(hash, "wrong"); err != nil || ok {
+				t.Errorf("Verify(wrong password) = %v, %v", ok, err)
+			}
+			if !tt.hasher.IsCurrent(hash) {
+				t.Error("IsCurrent(own hash) = false")
+			}
+			if other, _ := tt.hasher.Hash("secret"); other == hash {
+				t.Error("two hashes of one password are equal, the salt is not random")
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordHash(t *testing.T) {
+	bcryptHash, _ := NewBcryptHasher(bcrypt.MinCost).Hash("secret")
+	argon2Hash, _ := NewArgon2idHasher(1, 1024, 1).Hash("secret")
+	tests := []struct {
+		name     string
+		hash     string
+		salt     string
+		password string
+		want     bool
+	}{
+		{"bcrypt", bcryptHash, "", "secret", true},
+		{"bcrypt wrong", bcryptHash, "", "wrong", false},
+		{"argon2id", argon2Hash, "", "secret", true},
+		{"argon2id wrong", argon2Hash, "", "wrong", false},
+		{"legacy md5", legacyHash("salt", "secret"), "salt", "secret", true},
+		{"legacy md5 upper case", strings.ToUpper(legacyHash("salt", "secret")), "salt", "secret", true},
+		{"legacy md5 wrong", legacyHash("salt", "secret"), "salt", "wrong", false},
+		{"legacy md5 other salt", legacyHash("salt", "secret"), "other", "secret", false},
+		{"empty hash", "", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyPasswordHash(tt.hash, tt.salt, tt.password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("verifyPasswordHash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestPasswordHashUpgrade checks which stored hashes VerifyUserPassword rewrites with
+// the configured hasher after a successful login.
+func TestPasswordHashUpgrade(t *testing.T) {
+	current := NewArgon2idHasher(1, 1024, 1)
+	currentHash, _ := current.Hash("secret")
+	outdatedHash, _ := NewArgon2idHasher(1, 2048, 1).Hash("secret")
+	bcryptHash, _ := NewBcryptHasher(bcrypt.MinCost).Hash("secret")
+	tests := []struct {
+		name    string
+		hash    string
+		upgrade bool
+	}{
+		{"legacy md5", legacyHash("salt", "secret"), true},
+		{"other algorithm", bcryptHash, true},
+		{"outdated settings", outdatedHash, true},
+		{"current", currentHash, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := !current.IsCurrent(tt.hash); got != tt.upgrade {
+				t.Errorf("upgrade = %v, want %v", got, tt.upgrade)
+			}
+		})
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  PasswordConfig
+		wantErr bool
+	}{
+		{"default", PasswordConfig{}, false},
+		{"bcrypt", PasswordConfig{Algorithm: PASSWORD_BCRYPT, BcryptCost: 12}, false},
+		{"bcrypt cost too low", PasswordConfig{Algorithm: PASSWORD_BCRYPT, BcryptCost: 1}, true},
+		{"argon2id", PasswordConfig{Algorithm: PASSWORD_ARGON2ID}, false},
+		{"md5", PasswordConfig{Algorithm: "md5"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewPasswordHasher(tt.config); (err != nil) != tt.wantErr {
+				t.Errorf("NewPasswordHasher error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
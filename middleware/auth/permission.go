@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"callcenter-api/common/log"
+	"callcenter-api/common/response"
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PERMISSION_ALL grants every permission.
+const PERMISSION_ALL = "*"
+
+type IPermissionResolver interface {
+	GetUserPermissions(ctx context.Context, domainId, userId, level string) ([]string, error)
+}
+
+// PermissionResolver resolves the permissions of authenticated users, when nil users
+// only get the permissions implied by their level.
+var PermissionResolver IPermissionResolver
+
+// resolvePermissions fills the permissions of the user of c. Superadmins and tenant
+// admins are granted every permission of the tenant they act on.
+func resolvePermissions(c *gin.Context) {
+	user, ok := GetUser(c)
+	if !ok || user.Permissions != nil {
+		return
+	}
+	if IsLevelAtLeast(user.Level, ADMIN) {
+		user.Permissions = []string{PERMISSION_ALL}
+		return
+	}
+	user.Permissions = []string{}
+	if PermissionResolver == nil {
+		return
+	}
+	domainId, _ := GetUserDomainId(c)
+	permissions, err := PermissionResolver.GetUserPermissions(c, domainId, user.Id, user.Level)
+	if err != nil {
+		log.Error(err)
+		return
+	}
+	user.Permissions = permissions
+}
+
+// MatchPermission reports whether the granted permission covers permission. A granted
+// permission ending with ".*" covers every permission under its prefix.
+func MatchPermission(granted, permission string) bool {
+	if granted == PERMISSION_ALL || granted == permission {
+		return true
+	}
+	if strings.HasSuffix(granted, ".*") {
+		return strings.HasPrefix(permission, strings.TrimSuffix(granted, "*"))
+	}
+	return false
+}
+
+func hasPermission(granted []string, permission string) bool {
+	for _, value := range granted {
+		if MatchPermission(value, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the user of the request has permission.
+func HasPermission(c *gin.Context, permission string) bool {
+	user, ok := GetUser(c)
+	if !ok {
+		return false
+	}
+	return hasPermission(user.Permissions, permission)
+}
+
+// RequirePermission allows the request only when the user has all of permissions.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if !HasPermission(c, permission) {
+				c.JSON(response.Forbidden())
+				c.Abort()
+				return
+			}
+		}
+	}
+}
+
+// RequireAnyPermission allows the request when the user has at least one of permissions.
+func RequireAnyPermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, permission := range permissions {
+			if HasPermission(c, permission) {
+				return
+			}
+		}
+		c.JSON(response.Forbidden())
+		c.Abort()
+	}
+}
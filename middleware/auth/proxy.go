@@ -1,24 +1,122 @@
 package auth
 
 import (
+	"callcenter-api/common/cache"
 	"callcenter-api/common/log"
+	"callcenter-api/common/response"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+var (
+	errUnauthorized = errors.New("unauthorized")
+	ErrCircuitOpen  = errors.New("auth server is unavailable, circuit is open")
+)
+
 type GoAuthMiddleware struct {
-	authUrl string
+	authUrl  string
+	client   *http.Client
+	cacheTTL time.Duration
+	retries  int
+	backoff  time.Duration
+	breaker  *circuitBreaker
 }
 
-func NewGoAuthMiddleware(authUrl string) IAuthMiddleware {
+// NewGoAuthMiddleware checks every request against the introspection endpoint of
+// another instance, through one shared client.
+func NewGoAuthMiddleware(config ProxyConfig) (IAuthMiddleware, error) {
+	tlsConfig, err := newProxyTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 3000
+	}
+	if config.MaxIdleConns == 0 {
+		config.MaxIdleConns = 100
+	}
+	if config.MaxIdleConnsPerHost == 0 {
+		config.MaxIdleConnsPerHost = 20
+	}
+	if config.IdleConnTimeout == 0 {
+		config.IdleConnTimeout = 90
+	}
+	if config.CacheTTL == 0 {
+		config.CacheTTL = 5
+	}
+	if config.Retries == 0 {
+		config.Retries = 2
+	}
+	if config.RetryBackoffMs == 0 {
+		config.RetryBackoffMs = 100
+	}
+	if config.BreakerThreshold == 0 {
+		config.BreakerThreshold = 5
+	}
+	if config.BreakerCooldown == 0 {
+		config.BreakerCooldown = 30
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.MaxIdleConns = config.MaxIdleConns
+	transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	transport.IdleConnTimeout = time.Duration(config.IdleConnTimeout) * time.Second
 	return &GoAuthMiddleware{
-		authUrl: authUrl,
+		authUrl: config.AuthUrl,
+		client: &http.Client{
+			Timeout:   time.Duration(config.Timeout) * time.Millisecond,
+			Transport: transport,
+		},
+		cacheTTL: time.Duration(config.CacheTTL) * time.Second,
+		retries:  config.Retries,
+		backoff:  time.Duration(config.RetryBackoffMs) * time.Millisecond,
+		breaker: &circuitBreaker{
+			threshold: config.BreakerThreshold,
+			cooldown:  time.Duration(config.BreakerCooldown) * time.Second,
+		},
+	}, nil
+}
+
+func newProxyTLSConfig(config ProxyConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.InsecureSkipVerify {
+		log.Warning("auth.proxy.insecure_skip_verify is set, the auth server certificate is not verified")
 	}
+	if len(config.CaFile) > 0 {
+		ca, err := os.ReadFile(config.CaFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth.proxy.ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("auth.proxy.ca_file has no certificate")
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.CertFile) > 0 || len(config.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("auth.proxy.cert_file: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
 }
 
 func (mdw *GoAuthMiddleware) AuthMiddleware() gin.HandlerFunc {
@@ -35,8 +133,8 @@ func (mdw *GoAuthMiddleware) AuthMiddleware() gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		GoAuthUser, err := mdw.postToAuthAPI(token)
-		if err != nil {
+		GoAuthUser, err := mdw.introspect(c.Request.Context(), token)
+		if err == errUnauthorized {
 			log.Error(err)
 			c.JSON(
 				http.StatusUnauthorized,
@@ -46,43 +144,168 @@ func (mdw *GoAuthMiddleware) AuthMiddleware() gin.HandlerFunc {
 			)
 			c.Abort()
 			return
+		} else if err != nil {
+			log.Error(err)
+			c.JSON(response.ServiceUnavailableMsg("auth server is unavailable"))
+			c.Abort()
+			return
 		}
 		c.Set("user", GoAuthUser)
 	}
 }
 
-func (mdw *GoAuthMiddleware) postToAuthAPI(token string) (*GoAuthUser, error) {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+// introspect returns the user of token from cache.MCache, or from the auth server
+// with retries while the circuit is closed. It gives up when ctx is done.
+func (mdw *GoAuthMiddleware) introspect(ctx context.Context, token string) (*GoAuthUser, error) {
+	hash := sha256.Sum256([]byte(token))
+	key := "goauth_introspect:" + hex.EncodeToString(hash[:])
+	if cache.MCache != nil && mdw.cacheTTL > 0 {
+		if value, err := cache.MCache.Get(key); err == nil && value != nil {
+			if user, ok := value.(GoAuthUser); ok {
+				return &user, nil
+			}
+		}
+	}
+	if !mdw.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+	var user *GoAuthUser
+	var err error
+	for attempt := 0; attempt <= mdw.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(mdw.backoff << (attempt - 1)):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			// the caller left, this says nothing about the auth server
+			mdw.breaker.release()
+			return nil, ctx.Err()
+		}
+		user, err = mdw.postToAuthAPI(ctx, token)
+		if err == nil || err == errUnauthorized {
+			break
+		}
+		log.Errorf("auth server call %d failed: %v", attempt+1, err)
 	}
-	req, err := http.NewRequest("POST", mdw.authUrl, nil)
+	if err != nil && ctx.Err() != nil {
+		mdw.breaker.release()
+		return nil, ctx.Err()
+	} else if err != nil && err != errUnauthorized {
+		mdw.breaker.fail()
+		return nil, err
+	}
+	mdw.breaker.succeed()
+	if err != nil {
+		return nil, err
+	}
+	if cache.MCache != nil && mdw.cacheTTL > 0 {
+		if err := cache.MCache.SetTTL(key, *user, mdw.cacheTTL); err != nil {
+			log.Error(err)
+		}
+	}
+	return user, nil
+}
+
+func (mdw *GoAuthMiddleware) postToAuthAPI(ctx context.Context, token string) (*GoAuthUser, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", mdw.authUrl, nil)
 	if err != nil {
-		log.Error(err)
 		return nil, err
 	}
 	req.Header.Set("Authorization", token)
 	req.Header.Set("Content-Type", "application/json")
-	//http fix request not close tcp
-	req.Header.Set("Connection", "close")
-	req.Close = true
-	client := &http.Client{
-		Timeout: 3 * time.Second,
-	}
-	client.Transport = tr
-	res, err := client.Do(req)
+	res, err := mdw.client.Do(req)
 	if err != nil {
-		log.Error(err)
 		return nil, err
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusAccepted {
-		return nil, errors.New("unauthorized")
+	if res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return nil, errUnauthorized
+	} else if res.StatusCode != http.StatusAccepted {
+		return nil, fmt.Errorf("auth server answered %d", res.StatusCode)
 	}
 	GoAuthUser := new(GoAuthUser)
 	err = json.NewDecoder(res.Body).Decode(GoAuthUser)
 	if err != nil {
-		log.Error(err)
 		return nil, err
 	}
 	return GoAuthUser, nil
 }
+
+// Ping calls authUrl without credentials, any answer but a server error means the
+// auth server is up.
+func (mdw *GoAuthMiddleware) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", mdw.authUrl, nil)
+	if err != nil {
+		return err
+	}
+	res, err := mdw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("auth server answered %d", res.StatusCode)
+	}
+	return nil
+}
+
+// PingAuthServer pings the auth server of the proxy mode, it does nothing when
+// AuthMdw is not a proxy.
+func PingAuthServer(ctx context.Context) error {
+	mdw, ok := AuthMdw.(*GoAuthMiddleware)
+	if !ok {
+		return nil
+	}
+	return mdw.Ping(ctx)
+}
+
+// circuitBreaker opens after threshold consecutive failures. After cooldown it is
+// half-open and lets a single probe through, which closes it on success and opens it
+// again right away on failure.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	} else if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// release ends a probe that could not tell whether the auth server is up.
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *circuitBreaker) fail() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		log.Errorf("auth server failed %d times, circuit is open for %s", b.failures, b.cooldown)
+	}
+}
+
+func (b *circuitBreaker) succeed() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	b.failures = 0
+}
@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps func(b *circuitBreaker)
+		allow []bool
+	}{
+		{"closed", func(b *circuitBreaker) {}, []bool{true, true}},
+		{"below threshold", func(b *circuitBreaker) {
+			b.fail()
+			b.fail()
+		}, []bool{true}},
+		{"open at threshold", func(b *circuitBreaker) {
+			b.fail()
+			b.fail()
+			b.fail()
+		}, []bool{false}},
+		{"success resets failures", func(b *circuitBreaker) {
+			b.fail()
+			b.fail()
+			b.succeed()
+			b.fail()
+		}, []bool{true}},
+		{"half-open lets a single probe through", func(b *circuitBreaker) {
+			b.failures = b.threshold
+			b.openUntil = time.Now().Add(-time.Second)
+		}, []bool{true, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &circuitBreaker{threshold: 3, cooldown: time.Minute}
+			tt.steps(b)
+			for i, want := range tt.allow {
+				if got := b.allow(); got != want {
+					t.Errorf("allow call %d = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	halfOpen := func() *circuitBreaker {
+		b := &circuitBreaker{threshold: 3, cooldown: time.Minute, failures: 3, openUntil: time.Now().Add(-time.Second)}
+		if !b.allow() {
+			t.Fatal("half-open breaker refused the probe")
+		}
+		return b
+	}
+
+	b := halfOpen()
+	b.succeed()
+	if !b.allow() || !b.allow() {
+		t.Error("breaker is not closed after a successful probe")
+	}
+
+	b = halfOpen()
+	b.fail()
+	if b.allow() {
+		t.Error("breaker is not open again after a failed probe")
+	}
+	if !b.openUntil.After(time.Now()) {
+		t.Error("failed probe did not restart the cooldown")
+	}
+
+	b = halfOpen()
+	b.release()
+	if !b.allow() {
+		t.Error("released probe is not let through again")
+	}
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"callcenter-api/common/response"
+	"callcenter-api/middleware/auth/goauth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// levelRanks orders the levels, a level is granted everything a lower level is.
+var levelRanks = map[string]int{
+	SUPERADMIN: 50,
+	ADMIN:      40,
+	MANAGER:    30,
+	LEADER:     20,
+	AGENT:      10,
+	USER:       10,
+}
+
+// LevelRank returns the rank of level, 0 for an unknown level.
+func LevelRank(level string) int {
+	return levelRanks[level]
+}
+
+// IsLevelAtLeast reports whether level is min or above it.
+func IsLevelAtLeast(level, min string) bool {
+	rank := LevelRank(level)
+	return rank > 0 && rank >= LevelRank(min)
+}
+
+// RequireLevel allows users with min level or above.
+func RequireLevel(min string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		level, ok := GetUserLevel(c)
+		if !ok || !IsLevelAtLeast(level, min) {
+			c.JSON(response.Forbidden())
+			c.Abort()
+			return
+		}
+	}
+}
+
+// RequireAnyLevel allows users with exactly one of levels.
+func RequireAnyLevel(levels ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		level, ok := GetUserLevel(c)
+		if ok && hasLevel(levels, level) {
+			return
+		}
+		c.JSON(response.Forbidden())
+		c.Abort()
+	}
+}
+
+func hasLevel(levels []string, level string) bool {
+	for _, value := range levels {
+		if value == level {
+			return true
+		}
+	}
+	return false
+}
+
+// RoutePermission is what a user needs to call a route, every field that is set must
+// be satisfied. An empty RoutePermission only needs an authenticated user.
+type RoutePermission struct {
+	MinLevel    string
+	Levels      []string
+	Scopes      []string
+	Permissions []string
+}
+
+func (p RoutePermission) allow(user *GoAuthUser) bool {
+	if len(p.MinLevel) > 0 && !IsLevelAtLeast(user.Level, p.MinLevel) {
+		return false
+	}
+	if len(p.Levels) > 0 && !hasLevel(p.Levels, user.Level) {
+		return false
+	}
+	for _, scope := range p.Scopes {
+		if !goauth.HasScope(user.Scopes, scope) {
+			return false
+		}
+	}
+	for _, permission := range p.Permissions {
+		if !hasPermission(user.Permissions, permission) {
+			return false
+		}
+	}
+	return true
+}
+
+var routePermissions = map[string]RoutePermission{}
+
+// RegisterRoutePermissions declares the permissions of routes keyed by method and
+// full path, such as "GET /v1/auth/sessions".
+func RegisterRoutePermissions(permissions map[string]RoutePermission) {
+	for route, permission := range permissions {
+		routePermissions[route] = permission
+	}
+}
+
+// Authorize checks the user against the permission declared for the matched route.
+// A route without a declared permission is forbidden.
+func Authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := GetUser(c)
+		if !ok {
+			c.JSON(response.Forbidden())
+			c.Abort()
+			return
+		}
+		permission, ok := routePermissions[c.Request.Method+" "+c.FullPath()]
+		if !ok || !permission.allow(user) {
+			c.JSON(response.Forbidden())
+			c.Abort()
+			return
+		}
+	}
+}
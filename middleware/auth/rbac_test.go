@@ -0,0 +1,74 @@
+package auth
+
+import "testing"
+
+func TestLevelRank(t *testing.T) {
+	ordered := []string{AGENT, LEADER, MANAGER, ADMIN, SUPERADMIN}
+	for i := 1; i < len(ordered); i++ {
+		if LevelRank(ordered[i]) <= LevelRank(ordered[i-1]) {
+			t.Errorf("LevelRank(%q) = %d is not above LevelRank(%q) = %d",
+				ordered[i], LevelRank(ordered[i]), ordered[i-1], LevelRank(ordered[i-1]))
+		}
+	}
+	tests := []struct {
+		level string
+		want  int
+	}{
+		{USER, LevelRank(AGENT)},
+		{"", 0},
+		{"root", 0},
+		{"Admin", 0},
+	}
+	for _, tt := range tests {
+		if got := LevelRank(tt.level); got != tt.want {
+			t.Errorf("LevelRank(%q) = %d, want %d", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestIsLevelAtLeast(t *testing.T) {
+	tests := []struct {
+		level string
+		min   string
+		want  bool
+	}{
+		{SUPERADMIN, ADMIN, true},
+		{ADMIN, ADMIN, true},
+		{MANAGER, ADMIN, false},
+		{AGENT, LEADER, false},
+		{USER, AGENT, true},
+		{"", AGENT, false},
+		{"root", "unknown", false},
+	}
+	for _, tt := range tests {
+		if got := IsLevelAtLeast(tt.level, tt.min); got != tt.want {
+			t.Errorf("IsLevelAtLeast(%q, %q) = %v, want %v", tt.level, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestRoutePermissionAllow(t *testing.T) {
+	user := &GoAuthUser{Level: MANAGER, Scopes: []string{"cdr:*"}, Permissions: []string{"report.view"}}
+	tests := []struct {
+		name       string
+		permission RoutePermission
+		want       bool
+	}{
+		{"empty", RoutePermission{}, true},
+		{"min level met", RoutePermission{MinLevel: LEADER}, true},
+		{"min level not met", RoutePermission{MinLevel: ADMIN}, false},
+		{"exact level", RoutePermission{Levels: []string{MANAGER, AGENT}}, true},
+		{"other levels", RoutePermission{Levels: []string{ADMIN}}, false},
+		{"scope", RoutePermission{Scopes: []string{"cdr:read"}}, true},
+		{"missing scope", RoutePermission{Scopes: []string{"cdr:read", "user:write"}}, false},
+		{"permission", RoutePermission{Permissions: []string{"report.view"}}, true},
+		{"missing permission", RoutePermission{Permissions: []string{"report.export"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.permission.allow(user); got != tt.want {
+				t.Errorf("allow = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
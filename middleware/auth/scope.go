@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"callcenter-api/common/response"
+	"callcenter-api/middleware/auth/goauth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireScopes allows the request only when the user has been granted all of scopes.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := GetUser(c)
+		if !ok {
+			c.JSON(response.Forbidden())
+			c.Abort()
+			return
+		}
+		for _, scope := range scopes {
+			if !goauth.HasScope(user.Scopes, scope) {
+				c.JSON(response.Forbidden())
+				c.Abort()
+				return
+			}
+		}
+	}
+}
+
+// RequireAnyScope allows the request when the user has been granted at least one of scopes.
+func RequireAnyScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := GetUser(c)
+		if ok {
+			for _, scope := range scopes {
+				if goauth.HasScope(user.Scopes, scope) {
+					return
+				}
+			}
+		}
+		c.JSON(response.Forbidden())
+		c.Abort()
+	}
+}
+
+// HasScope reports whether the user of the request has been granted scope.
+func HasScope(c *gin.Context, scope string) bool {
+	user, ok := GetUser(c)
+	if !ok {
+		return false
+	}
+	return goauth.HasScope(user.Scopes, scope)
+}
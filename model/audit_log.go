@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type AuditLog struct {
+	bun.BaseModel      `bun:"table:auth_audit_logs,alias:aal"`
+	Id                 string    `json:"id" bun:"id,pk,type:char(36)"`
+	Action             string    `json:"action" bun:"action,type:varchar(50),notnull"`
+	UserUuid           string    `json:"user_uuid" bun:"user_uuid,type:varchar(100),notnull"`
+	Username           string    `json:"username" bun:"username,type:varchar(255)"`
+	OriginalDomainUuid string    `json:"original_domain_uuid" bun:"original_domain_uuid,type:varchar(100)"`
+	DomainUuid         string    `json:"domain_uuid" bun:"domain_uuid,type:varchar(100)"`
+	Method             string    `json:"method" bun:"method,type:varchar(10)"`
+	Path               string    `json:"path" bun:"path,type:text"`
+	IpAddress          string    `json:"ip_address" bun:"ip_address,type:varchar(50)"`
+	Status             int       `json:"status" bun:"status"`
+	CreatedAt          time.Time `json:"created_at" bun:"created_at,type:timestamp,notnull,default:current_timestamp"`
+}
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// UserMfa is the TOTP enrollment of a user. Secret is encrypted and recovery codes
+// are stored as sha256 hashes.
+type UserMfa struct {
+	bun.BaseModel `bun:"table:auth_user_mfa,alias:aum"`
+	UserUuid      string    `json:"user_uuid" bun:"user_uuid,pk,type:char(36)"`
+	Secret        string    `json:"-" bun:"secret,type:text,notnull"`
+	Enabled       bool      `json:"enabled" bun:"enabled,notnull,default:false"`
+	RecoveryCodes []string  `json:"-" bun:"recovery_codes,type:text"`
+	LastUsedStep  int64     `json:"-" bun:"last_used_step,notnull,default:0"`
+	ConfirmedAt   time.Time `json:"confirmed_at" bun:"confirmed_at,type:timestamp,nullzero"`
+	CreatedAt     time.Time `json:"created_at" bun:"created_at,type:timestamp,notnull,default:current_timestamp"`
+	UpdatedAt     time.Time `json:"updated_at" bun:"updated_at,type:timestamp,notnull,default:current_timestamp"`
+}
+
+type MfaStatus struct {
+	Enabled           bool      `json:"enabled"`
+	Required          bool      `json:"required"`
+	RecoveryCodesLeft int       `json:"recovery_codes_left"`
+	ConfirmedAt       time.Time `json:"confirmed_at,omitempty"`
+}
+
+type MfaSetup struct {
+	Secret     string `json:"secret"`
+	OtpauthUrl string `json:"otpauth_url"`
+}
+
+type MfaRequest struct {
+	Otp string `json:"otp"`
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type OAuthClient struct {
+	bun.BaseModel    `bun:"table:oauth_clients,alias:oc"`
+	ClientId         string    `json:"client_id" bun:"client_id,pk,type:varchar(100)"`
+	Name             string    `json:"name" bun:"name,type:varchar(255),notnull"`
+	ClientSecret     string    `json:"-" bun:"client_secret,type:text"`
+	GrantTypes       []string  `json:"grant_types" bun:"grant_types,type:text"`
+	Scopes           []string  `json:"scopes" bun:"scopes,type:text"`
+	ExpiredIn        int       `json:"expired_in" bun:"expired_in,notnull,default:0"`
+	RefreshExpiredIn int       `json:"refresh_expired_in" bun:"refresh_expired_in,notnull,default:0"`
+	Enabled          bool      `json:"enabled" bun:"enabled,notnull,default:true"`
+	CreatedAt        time.Time `json:"created_at" bun:"created_at,type:timestamp,notnull,default:current_timestamp"`
+	UpdatedAt        time.Time `json:"updated_at" bun:"updated_at,type:timestamp,notnull,default:current_timestamp"`
+}
+
+type OAuthClientRequest struct {
+	ClientId         string   `json:"client_id"`
+	Name             string   `json:"name"`
+	IsConfidential   bool     `json:"is_confidential"`
+	GrantTypes       []string `json:"grant_types"`
+	Scopes           []string `json:"scopes"`
+	ExpiredIn        int      `json:"expired_in"`
+	RefreshExpiredIn int      `json:"refresh_expired_in"`
+	Enabled          *bool    `json:"enabled"`
+}
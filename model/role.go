@@ -0,0 +1,49 @@
+package model
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Permission is an action that can be granted to a role, such as cdr.export.
+type Permission struct {
+	bun.BaseModel `bun:"table:auth_permissions,alias:ap"`
+	Permission    string `json:"permission" bun:"permission,pk,type:varchar(100)"`
+	Description   string `json:"description" bun:"description,type:varchar(255)"`
+}
+
+// Role groups permissions of a tenant. A role without domain_uuid is shared by every
+// tenant and is managed by superadmins. Users are given their roles through
+// UserRole, a user without any falls back to the roles whose default_level is its level.
+type Role struct {
+	bun.BaseModel `bun:"table:auth_roles,alias:ar"`
+	RoleUuid      string    `json:"role_uuid" bun:"role_uuid,pk,type:char(36)"`
+	DomainUuid    string    `json:"domain_uuid" bun:"domain_uuid,type:char(36),nullzero"`
+	Name          string    `json:"name" bun:"name,type:varchar(100),notnull"`
+	Description   string    `json:"description" bun:"description,type:varchar(255)"`
+	DefaultLevel  string    `json:"default_level" bun:"default_level,type:varchar(50)"`
+	Permissions   []string  `json:"permissions" bun:"permissions,type:text"`
+	CreatedAt     time.Time `json:"created_at" bun:"created_at,type:timestamp,notnull,default:current_timestamp"`
+	UpdatedAt     time.Time `json:"updated_at" bun:"updated_at,type:timestamp,notnull,default:current_timestamp"`
+}
+
+type UserRole struct {
+	bun.BaseModel `bun:"table:auth_user_roles,alias:aur"`
+	UserUuid      string    `json:"user_uuid" bun:"user_uuid,pk,type:char(36)"`
+	RoleUuid      string    `json:"role_uuid" bun:"role_uuid,pk,type:char(36)"`
+	DomainUuid    string    `json:"domain_uuid" bun:"domain_uuid,type:char(36),notnull"`
+	CreatedAt     time.Time `json:"created_at" bun:"created_at,type:timestamp,notnull,default:current_timestamp"`
+}
+
+type RoleRequest struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	DefaultLevel string   `json:"default_level"`
+	Permissions  []string `json:"permissions"`
+	IsGlobal     bool     `json:"is_global"`
+}
+
+type UserRoleRequest struct {
+	RoleUuids []string `json:"role_uuids"`
+}
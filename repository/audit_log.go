@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"callcenter-api/model"
+	"context"
+)
+
+type IAuditLog interface {
+	Insert(ctx context.Context, auditLog *model.AuditLog) error
+}
+
+var AuditLogRepo IAuditLog
+
+type AuditLog struct {
+}
+
+func NewAuditLog() IAuditLog {
+	repo := &AuditLog{}
+	if err := CreateTable(FusionSqlClient, context.Background(), (*model.AuditLog)(nil)); err != nil {
+		panic(err)
+	}
+	return repo
+}
+
+func (repo *AuditLog) Insert(ctx context.Context, auditLog *model.AuditLog) error {
+	_, err := FusionSqlClient.GetDB().NewInsert().Model(auditLog).Exec(ctx)
+	return err
+}
@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"callcenter-api/model"
+	"context"
+	"database/sql"
+	"time"
+)
+
+type IMfa interface {
+	GetByUser(ctx context.Context, userUuid string) (*model.UserMfa, error)
+	Upsert(ctx context.Context, userMfa *model.UserMfa) error
+	Update(ctx context.Context, userMfa *model.UserMfa) error
+	// UpdateLastUsedStep stores step when it is newer than the stored one and reports
+	// whether it did, so that a code is accepted once even with concurrent requests.
+	UpdateLastUsedStep(ctx context.Context, userUuid string, step int64) (bool, error)
+	// RemoveRecoveryCode removes the recovery code hash when it is still stored and
+	// reports whether it did, so that a code is spent once even with concurrent requests.
+	RemoveRecoveryCode(ctx context.Context, userUuid, hash string) (bool, error)
+	Delete(ctx context.Context, userUuid string) error
+}
+
+var MfaRepo IMfa
+
+type Mfa struct {
+}
+
+func NewMfa() IMfa {
+	repo := &Mfa{}
+	if err := CreateTable(FusionSqlClient, context.Background(), (*model.UserMfa)(nil)); err != nil {
+		panic(err)
+	}
+	return repo
+}
+
+func (repo *Mfa) GetByUser(ctx context.Context, userUuid string) (*model.UserMfa, error) {
+	userMfa := new(model.UserMfa)
+	err := FusionSqlClient.GetDB().NewSelect().Model(userMfa).
+		Where("user_uuid = ?", userUuid).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return userMfa, nil
+}
+
+func (repo *Mfa) Upsert(ctx context.Context, userMfa *model.UserMfa) error {
+	_, err := FusionSqlClient.GetDB().NewInsert().Model(userMfa).
+		On("CONFLICT (user_uuid) DO UPDATE").
+		Set("secret = EXCLUDED.secret").
+		Set("enabled = EXCLUDED.enabled").
+		Set("recovery_codes = EXCLUDED.recovery_codes").
+		Set("last_used_step = EXCLUDED.last_used_step").
+		Set("confirmed_at = EXCLUDED.confirmed_at").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	return err
+}
+
+func (repo *Mfa) Update(ctx context.Context, userMfa *model.UserMfa) error {
+	_, err := FusionSqlClient.GetDB().NewUpdate().Model(userMfa).WherePK().Exec(ctx)
+	return err
+}
+
+func (repo *Mfa) UpdateLastUsedStep(ctx context.Context, userUuid string, step int64) (bool, error) {
+	res, err := FusionSqlClient.GetDB().NewUpdate().Model((*model.UserMfa)(nil)).
+		Set("last_used_step = ?", step).
+		Where("user_uuid = ?", userUuid).
+		Where("last_used_step < ?", step).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+func (repo *Mfa) RemoveRecoveryCode(ctx context.Context, userUuid, hash string) (bool, error) {
+	// recovery_codes holds a JSON array
+	res, err := FusionSqlClient.GetDB().NewUpdate().Model((*model.UserMfa)(nil)).
+		Set("recovery_codes = (recovery_codes::jsonb - ?::text)::text", hash).
+		Set("updated_at = ?", time.Now()).
+		Where("user_uuid = ?", userUuid).
+		Where("jsonb_exists(recovery_codes::jsonb, ?)", hash).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	return count > 0, err
+}
+
+func (repo *Mfa) Delete(ctx context.Context, userUuid string) error {
+	_, err := FusionSqlClient.GetDB().NewDelete().Model((*model.UserMfa)(nil)).
+		Where("user_uuid = ?", userUuid).
+		Exec(ctx)
+	return err
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"callcenter-api/model"
+	"context"
+	"database/sql"
+)
+
+type IOAuthClient interface {
+	Insert(ctx context.Context, client *model.OAuthClient) error
+	Update(ctx context.Context, client *model.OAuthClient) error
+	Delete(ctx context.Context, clientId string) error
+	GetById(ctx context.Context, clientId string) (*model.OAuthClient, error)
+	GetClients(ctx context.Context, limit, offset int) (int, *[]model.OAuthClient, error)
+}
+
+var OAuthClientRepo IOAuthClient
+
+type OAuthClient struct {
+}
+
+func NewOAuthClient() IOAuthClient {
+	repo := &OAuthClient{}
+	if err := CreateTable(FusionSqlClient, context.Background(), (*model.OAuthClient)(nil)); err != nil {
+		panic(err)
+	}
+	return repo
+}
+
+func (repo *OAuthClient) Insert(ctx context.Context, client *model.OAuthClient) error {
+	_, err := FusionSqlClient.GetDB().NewInsert().Model(client).Exec(ctx)
+	return err
+}
+
+func (repo *OAuthClient) Update(ctx context.Context, client *model.OAuthClient) error {
+	_, err := FusionSqlClient.GetDB().NewUpdate().Model(client).WherePK().Exec(ctx)
+	return err
+}
+
+func (repo *OAuthClient) Delete(ctx context.Context, clientId string) error {
+	_, err := FusionSqlClient.GetDB().NewDelete().Model((*model.OAuthClient)(nil)).
+		Where("client_id = ?", clientId).
+		Exec(ctx)
+	return err
+}
+
+func (repo *OAuthClient) GetById(ctx context.Context, clientId string) (*model.OAuthClient, error) {
+	client := new(model.OAuthClient)
+	err := FusionSqlClient.GetDB().NewSelect().Model(client).
+		Where("client_id = ?", clientId).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (repo *OAuthClient) GetClients(ctx context.Context, limit, offset int) (int, *[]model.OAuthClient, error) {
+	clients := new([]model.OAuthClient)
+	query := FusionSqlClient.GetDB().NewSelect().Model(clients).
+		Order("created_at DESC")
+	if limit > 0 {
+		query.Limit(limit).Offset(offset)
+	}
+	total, err := query.ScanAndCount(ctx)
+	if err == sql.ErrNoRows {
+		return 0, clients, nil
+	} else if err != nil {
+		return 0, nil, err
+	}
+	return total, clients, nil
+}
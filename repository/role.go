@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"callcenter-api/model"
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type IRole interface {
+	Insert(ctx context.Context, role *model.Role) error
+	Update(ctx context.Context, role *model.Role) error
+	Delete(ctx context.Context, roleUuid string) error
+	GetById(ctx context.Context, roleUuid string) (*model.Role, error)
+	GetRoles(ctx context.Context, domainUuid string, limit, offset int) (int, *[]model.Role, error)
+	GetPermissions(ctx context.Context) (*[]model.Permission, error)
+	InsertPermissions(ctx context.Context, permissions *[]model.Permission) error
+	GetUserRoles(ctx context.Context, domainUuid, userUuid string) (*[]model.Role, error)
+	GetDefaultRoles(ctx context.Context, domainUuid, level string) (*[]model.Role, error)
+	SetUserRoles(ctx context.Context, domainUuid, userUuid string, roleUuids []string) error
+}
+
+var RoleRepo IRole
+
+type Role struct {
+}
+
+func NewRole() IRole {
+	repo := &Role{}
+	for _, table := range []interface{}{(*model.Permission)(nil), (*model.Role)(nil), (*model.UserRole)(nil)} {
+		if err := CreateTable(FusionSqlClient, context.Background(), table); err != nil {
+			panic(err)
+		}
+	}
+	return repo
+}
+
+func (repo *Role) Insert(ctx context.Context, role *model.Role) error {
+	_, err := FusionSqlClient.GetDB().NewInsert().Model(role).Exec(ctx)
+	return err
+}
+
+func (repo *Role) Update(ctx context.Context, role *model.Role) error {
+	_, err := FusionSqlClient.GetDB().NewUpdate().Model(role).WherePK().Exec(ctx)
+	return err
+}
+
+func (repo *Role) Delete(ctx context.Context, roleUuid string) error {
+	return FusionSqlClient.GetDB().RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewDelete().Model((*model.UserRole)(nil)).
+			Where("role_uuid = ?", roleUuid).
+			Exec(ctx); err != nil {
+			return err
+		}
+		_, err := tx.NewDelete().Model((*model.Role)(nil)).
+			Where("role_uuid = ?", roleUuid).
+			Exec(ctx)
+		return err
+	})
+}
+
+func (repo *Role) GetById(ctx context.Context, roleUuid string) (*model.Role, error) {
+	role := new(model.Role)
+	err := FusionSqlClient.GetDB().NewSelect().Model(role).
+		Where("role_uuid = ?", roleUuid).
+		Scan(ctx)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return role, nil
+}
+
+// GetRoles returns the roles of domainUuid together with the shared roles.
+func (repo *Role) GetRoles(ctx context.Context, domainUuid string, limit, offset int) (int, *[]model.Role, error) {
+	roles := new([]model.Role)
+	query := FusionSqlClient.GetDB().NewSelect().Model(roles).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("domain_uuid = ?", domainUuid).WhereOr("domain_uuid IS NULL")
+		}).
+		Order("created_at DESC")
+	if limit > 0 {
+		query.Limit(limit).Offset(offset)
+	}
+	total, err := query.ScanAndCount(ctx)
+	if err == sql.ErrNoRows {
+		return 0, roles, nil
+	} else if err != nil {
+		return 0, nil, err
+	}
+	return total, roles, nil
+}
+
+func (repo *Role) GetPermissions(ctx context.Context) (*[]model.Permission, error) {
+	permissions := new([]model.Permission)
+	err := FusionSqlClient.GetDB().NewSelect().Model(permissions).
+		Order("permission ASC").
+		Scan(ctx)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return permissions, nil
+}
+
+// InsertPermissions adds the permissions that are not stored yet.
+func (repo *Role) InsertPermissions(ctx context.Context, permissions *[]model.Permission) error {
+	_, err := FusionSqlClient.GetDB().NewInsert().Model(permissions).
+		Ignore().
+		Exec(ctx)
+	return err
+}
+
+func (repo *Role) GetUserRoles(ctx context.Context, domainUuid, userUuid string) (*[]model.Role, error) {
+	roles := new([]model.Role)
+	err := FusionSqlClient.GetDB().NewSelect().Model(roles).
+		Join("inner join auth_user_roles aur on aur.role_uuid = ar.role_uuid").
+		Where("aur.user_uuid = ?", userUuid).
+		Where("aur.domain_uuid = ?", domainUuid).
+		Scan(ctx)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (repo *Role) GetDefaultRoles(ctx context.Context, domainUuid, level string) (*[]model.Role, error) {
+	roles := new([]model.Role)
+	err := FusionSqlClient.GetDB().NewSelect().Model(roles).
+		Where("default_level = ?", level).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("domain_uuid = ?", domainUuid).WhereOr("domain_uuid IS NULL")
+		}).
+		Scan(ctx)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// SetUserRoles replaces the roles of userUuid in domainUuid.
+func (repo *Role) SetUserRoles(ctx context.Context, domainUuid, userUuid string, roleUuids []string) error {
+	return FusionSqlClient.GetDB().RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewDelete().Model((*model.UserRole)(nil)).
+			Where("user_uuid = ?", userUuid).
+			Where("domain_uuid = ?", domainUuid).
+			Exec(ctx); err != nil {
+			return err
+		}
+		if len(roleUuids) < 1 {
+			return nil
+		}
+		userRoles := make([]model.UserRole, 0, len(roleUuids))
+		for _, roleUuid := range roleUuids {
+			userRoles = append(userRoles, model.UserRole{
+				UserUuid:   userUuid,
+				RoleUuid:   roleUuid,
+				DomainUuid: domainUuid,
+				CreatedAt:  time.Now(),
+			})
+		}
+		_, err := tx.NewInsert().Model(&userRoles).Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,374 @@
+package service
+
+import (
+	authMdw "callcenter-api/middleware/auth"
+	"callcenter-api/middleware/auth/goauth"
+	"callcenter-api/model"
+	"callcenter-api/repository"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod        = 30
+	totpDigits        = 6
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrMfaNotEnrolled     = errors.New("second factor is not enrolled")
+	ErrMfaAlreadyEnrolled = errors.New("second factor is already enrolled")
+	ErrMfaMandatory       = errors.New("second factor is mandatory for this level")
+)
+
+type MfaConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// EncryptionKey is the base64 of a 32 bytes AES key for the TOTP secrets.
+	EncryptionKey    string   `mapstructure:"encryption_key"`
+	Issuer           string   `mapstructure:"issuer"`
+	PendingExpiredIn int      `mapstructure:"pending_expired_in"`
+	RequiredLevels   []string `mapstructure:"required_levels"`
+}
+
+type IMfa interface {
+	authMdw.IMfa
+	Status(ctx context.Context, userId, level string) (*model.MfaStatus, error)
+	Setup(ctx context.Context, userId, account string) (*model.MfaSetup, error)
+	Confirm(ctx context.Context, userId, otp string) ([]string, error)
+	Disable(ctx context.Context, userId, level, otp string) error
+	Reset(ctx context.Context, userId string) error
+	RegenerateRecoveryCodes(ctx context.Context, userId, otp string) ([]string, error)
+}
+
+type Mfa struct {
+	aead             cipher.AEAD
+	issuer           string
+	pendingExpiredIn int
+	requiredLevels   []string
+}
+
+func NewMfa(config MfaConfig) (IMfa, error) {
+	key, err := base64.StdEncoding.DecodeString(config.EncryptionKey)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("mfa.encryption_key must be the base64 of 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	// the mfa_pending tokens are signed with a secret derived from the same key
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(goauth.MFA_PENDING))
+	if err := goauth.SetMfaSecret(hex.EncodeToString(mac.Sum(nil))); err != nil {
+		return nil, err
+	}
+	s := &Mfa{
+		aead:             aead,
+		issuer:           config.Issuer,
+		pendingExpiredIn: config.PendingExpiredIn,
+		requiredLevels:   config.RequiredLevels,
+	}
+	if len(s.issuer) < 1 {
+		s.issuer = "callcenter-api"
+	}
+	if s.pendingExpiredIn <= 0 {
+		s.pendingExpiredIn = 300
+	}
+	if config.RequiredLevels == nil {
+		s.requiredLevels = []string{authMdw.SUPERADMIN, authMdw.ADMIN}
+	}
+	return s, nil
+}
+
+func (s *Mfa) PendingExpiredIn() int {
+	return s.pendingExpiredIn
+}
+
+func (s *Mfa) isRequiredLevel(level string) bool {
+	for _, value := range s.requiredLevels {
+		if value == level {
+			return true
+		}
+	}
+	return false
+}
+
+// Check implements auth.IMfa.
+func (s *Mfa) Check(ctx context.Context, userId, level string) (bool, bool, error) {
+	userMfa, err := repository.MfaRepo.GetByUser(ctx, userId)
+	if err != nil {
+		return false, false, err
+	}
+	enrolled := userMfa != nil && userMfa.Enabled
+	return enrolled || s.isRequiredLevel(level), enrolled, nil
+}
+
+// Verify implements auth.IMfa. A TOTP code is accepted once, a recovery code is
+// removed when it is used.
+func (s *Mfa) Verify(ctx context.Context, userId, code string) error {
+	userMfa, err := repository.MfaRepo.GetByUser(ctx, userId)
+	if err != nil {
+		return err
+	} else if userMfa == nil || !userMfa.Enabled {
+		return ErrMfaNotEnrolled
+	}
+	code = strings.TrimSpace(code)
+	if len(code) == totpDigits {
+		return s.verifyTotp(ctx, userMfa, code)
+	}
+	return s.useRecoveryCode(ctx, userMfa, code)
+}
+
+func (s *Mfa) verifyTotp(ctx context.Context, userMfa *model.UserMfa, code string) error {
+	secret, err := s.decrypt(userMfa.Secret)
+	if err != nil {
+		return err
+	}
+	step, ok := matchTotp(secret, code, time.Now())
+	if !ok {
+		return authMdw.ErrMfaInvalidCode
+	}
+	if userMfa.Enabled {
+		updated, err := repository.MfaRepo.UpdateLastUsedStep(ctx, userMfa.UserUuid, step)
+		if err != nil {
+			return err
+		} else if !updated {
+			return authMdw.ErrMfaInvalidCode
+		}
+	}
+	return nil
+}
+
+func (s *Mfa) useRecoveryCode(ctx context.Context, userMfa *model.UserMfa, code string) error {
+	hash := hashRecoveryCode(code)
+	for _, value := range userMfa.RecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(hash)) == 1 {
+			removed, err := repository.MfaRepo.RemoveRecoveryCode(ctx, userMfa.UserUuid, hash)
+			if err != nil {
+				return err
+			} else if !removed {
+				// spent by a concurrent request
+				return authMdw.ErrMfaInvalidCode
+			}
+			return nil
+		}
+	}
+	return authMdw.ErrMfaInvalidCode
+}
+
+func (s *Mfa) Status(ctx context.Context, userId, level string) (*model.MfaStatus, error) {
+	userMfa, err := repository.MfaRepo.GetByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	status := &model.MfaStatus{
+		Required: s.isRequiredLevel(level),
+	}
+	if userMfa != nil && userMfa.Enabled {
+		status.Enabled = true
+		status.RecoveryCodesLeft = len(userMfa.RecoveryCodes)
+		status.ConfirmedAt = userMfa.ConfirmedAt
+	}
+	return status, nil
+}
+
+// Setup generates a new secret for userId, it is used once Confirm verifies a code
+// of it. account names the secret in authenticator apps.
+func (s *Mfa) Setup(ctx context.Context, userId, account string) (*model.MfaSetup, error) {
+	userMfa, err := repository.MfaRepo.GetByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	} else if userMfa != nil && userMfa.Enabled {
+		return nil, ErrMfaAlreadyEnrolled
+	}
+	secret := make([]byte, 20)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	encrypted, err := s.encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := repository.MfaRepo.Upsert(ctx, &model.UserMfa{
+		UserUuid:  userId,
+		Secret:    encrypted,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+	encoded := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secret)
+	query := url.Values{}
+	query.Set("secret", encoded)
+	query.Set("issuer", s.issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	otpauthUrl := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + s.issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+	return &model.MfaSetup{
+		Secret:     encoded,
+		OtpauthUrl: otpauthUrl.String(),
+	}, nil
+}
+
+// Confirm enables the secret of Setup when otp matches it and returns new recovery codes.
+func (s *Mfa) Confirm(ctx context.Context, userId, otp string) ([]string, error) {
+	userMfa, err := repository.MfaRepo.GetByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	} else if userMfa == nil {
+		return nil, ErrMfaNotEnrolled
+	} else if userMfa.Enabled {
+		return nil, ErrMfaAlreadyEnrolled
+	}
+	secret, err := s.decrypt(userMfa.Secret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := matchTotp(secret, strings.TrimSpace(otp), time.Now())
+	if !ok {
+		return nil, authMdw.ErrMfaInvalidCode
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	userMfa.Enabled = true
+	userMfa.RecoveryCodes = hashes
+	userMfa.LastUsedStep = step
+	userMfa.ConfirmedAt = time.Now()
+	userMfa.UpdatedAt = time.Now()
+	if err := repository.MfaRepo.Update(ctx, userMfa); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable removes the second factor of a user whose level does not require it.
+func (s *Mfa) Disable(ctx context.Context, userId, level, otp string) error {
+	if s.isRequiredLevel(level) {
+		return ErrMfaMandatory
+	}
+	if err := s.Verify(ctx, userId, otp); err != nil {
+		return err
+	}
+	return repository.MfaRepo.Delete(ctx, userId)
+}
+
+// Reset removes the second factor of userId so that it enrolls again, for a lost device.
+func (s *Mfa) Reset(ctx context.Context, userId string) error {
+	return repository.MfaRepo.Delete(ctx, userId)
+}
+
+func (s *Mfa) RegenerateRecoveryCodes(ctx context.Context, userId, otp string) ([]string, error) {
+	if err := s.Verify(ctx, userId, otp); err != nil {
+		return nil, err
+	}
+	userMfa, err := repository.MfaRepo.GetByUser(ctx, userId)
+	if err != nil {
+		return nil, err
+	} else if userMfa == nil {
+		return nil, ErrMfaNotEnrolled
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	userMfa.RecoveryCodes = hashes
+	userMfa.UpdatedAt = time.Now()
+	if err := repository.MfaRepo.Update(ctx, userMfa); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *Mfa) encrypt(plain []byte) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(s.aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+func (s *Mfa) decrypt(encrypted string) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < s.aead.NonceSize() {
+		return nil, errors.New("mfa secret is invalid")
+	}
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	return s.aead.Open(nil, nonce, ciphertext, nil)
+}
+
+// totpCode computes the code of RFC 6238 with HMAC-SHA1 for a time step.
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// matchTotp looks for code around now and returns its time step.
+func matchTotp(secret []byte, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / totpPeriod
+	matched := int64(-1)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			matched = step
+		}
+	}
+	return matched, matched >= 0
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(buf))
+		code = code[:4] + "-" + code[4:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
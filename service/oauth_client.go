@@ -0,0 +1,197 @@
+package service
+
+import (
+	"callcenter-api/middleware/auth/goauth"
+	"callcenter-api/model"
+	"callcenter-api/repository"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrOAuthClientNotFound = errors.New("client is not found")
+
+type IOAuthClient interface {
+	goauth.ClientRegistry
+	CreateClient(ctx context.Context, request model.OAuthClientRequest) (*model.OAuthClient, string, error)
+	UpdateClient(ctx context.Context, clientId string, request model.OAuthClientRequest) (*model.OAuthClient, error)
+	DeleteClient(ctx context.Context, clientId string) error
+	GetClientById(ctx context.Context, clientId string) (*model.OAuthClient, error)
+	GetClients(ctx context.Context, limit, offset int) (int, *[]model.OAuthClient, error)
+	RotateSecret(ctx context.Context, clientId string) (string, error)
+}
+
+type OAuthClient struct {
+}
+
+func NewOAuthClient() IOAuthClient {
+	return &OAuthClient{}
+}
+
+// GetClient implements goauth.ClientRegistry.
+func (s *OAuthClient) GetClient(ctx context.Context, clientId string) (*goauth.RegisteredClient, error) {
+	client, err := repository.OAuthClientRepo.GetById(ctx, clientId)
+	if err != nil {
+		return nil, err
+	} else if client == nil {
+		return nil, nil
+	}
+	return &goauth.RegisteredClient{
+		ClientId:         client.ClientId,
+		SecretHash:       client.ClientSecret,
+		GrantTypes:       client.GrantTypes,
+		Scopes:           client.Scopes,
+		ExpiredIn:        client.ExpiredIn,
+		RefreshExpiredIn: client.RefreshExpiredIn,
+		Enabled:          client.Enabled,
+	}, nil
+}
+
+// CreateClient registers a client. For a confidential client the generated secret is
+// returned, it is only stored hashed and can not be read again.
+func (s *OAuthClient) CreateClient(ctx context.Context, request model.OAuthClientRequest) (*model.OAuthClient, string, error) {
+	if err := validateOAuthClientRequest(request); err != nil {
+		return nil, "", err
+	}
+	if len(request.ClientId) < 1 {
+		request.ClientId = uuid.NewString()
+	}
+	existed, err := repository.OAuthClientRepo.GetById(ctx, request.ClientId)
+	if err != nil {
+		return nil, "", err
+	} else if existed != nil {
+		return nil, "", errors.New("client_id is already existed")
+	}
+	client := &model.OAuthClient{
+		ClientId:         request.ClientId,
+		Name:             request.Name,
+		GrantTypes:       request.GrantTypes,
+		Scopes:           request.Scopes,
+		ExpiredIn:        request.ExpiredIn,
+		RefreshExpiredIn: request.RefreshExpiredIn,
+		Enabled:          true,
+		CreatedAt:        time.Now(),
+		UpdatedAt:        time.Now(),
+	}
+	if request.Enabled != nil {
+		client.Enabled = *request.Enabled
+	}
+	secret := ""
+	if request.IsConfidential {
+		secret, client.ClientSecret, err = generateClientSecret()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if err := repository.OAuthClientRepo.Insert(ctx, client); err != nil {
+		return nil, "", err
+	}
+	return client, secret, nil
+}
+
+func (s *OAuthClient) UpdateClient(ctx context.Context, clientId string, request model.OAuthClientRequest) (*model.OAuthClient, error) {
+	if err := validateOAuthClientRequest(request); err != nil {
+		return nil, err
+	}
+	client, err := repository.OAuthClientRepo.GetById(ctx, clientId)
+	if err != nil {
+		return nil, err
+	} else if client == nil {
+		return nil, ErrOAuthClientNotFound
+	}
+	client.Name = request.Name
+	client.GrantTypes = request.GrantTypes
+	client.Scopes = request.Scopes
+	client.ExpiredIn = request.ExpiredIn
+	client.RefreshExpiredIn = request.RefreshExpiredIn
+	if request.Enabled != nil {
+		client.Enabled = *request.Enabled
+	}
+	client.UpdatedAt = time.Now()
+	if err := repository.OAuthClientRepo.Update(ctx, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (s *OAuthClient) DeleteClient(ctx context.Context, clientId string) error {
+	client, err := repository.OAuthClientRepo.GetById(ctx, clientId)
+	if err != nil {
+		return err
+	} else if client == nil {
+		return ErrOAuthClientNotFound
+	}
+	return repository.OAuthClientRepo.Delete(ctx, clientId)
+}
+
+func (s *OAuthClient) GetClientById(ctx context.Context, clientId string) (*model.OAuthClient, error) {
+	client, err := repository.OAuthClientRepo.GetById(ctx, clientId)
+	if err != nil {
+		return nil, err
+	} else if client == nil {
+		return nil, ErrOAuthClientNotFound
+	}
+	return client, nil
+}
+
+func (s *OAuthClient) GetClients(ctx context.Context, limit, offset int) (int, *[]model.OAuthClient, error) {
+	return repository.OAuthClientRepo.GetClients(ctx, limit, offset)
+}
+
+// RotateSecret replaces the secret of a client, which also makes a public client confidential.
+func (s *OAuthClient) RotateSecret(ctx context.Context, clientId string) (string, error) {
+	client, err := repository.OAuthClientRepo.GetById(ctx, clientId)
+	if err != nil {
+		return "", err
+	} else if client == nil {
+		return "", ErrOAuthClientNotFound
+	}
+	secret, hash, err := generateClientSecret()
+	if err != nil {
+		return "", err
+	}
+	client.ClientSecret = hash
+	client.UpdatedAt = time.Now()
+	if err := repository.OAuthClientRepo.Update(ctx, client); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+func validateOAuthClientRequest(request model.OAuthClientRequest) error {
+	if len(request.Name) < 1 {
+		return errors.New("name is required")
+	}
+	if len(request.GrantTypes) < 1 {
+		return errors.New("grant_types is required")
+	}
+	for _, grantType := range request.GrantTypes {
+		switch grantType {
+		case goauth.GRANT_CLIENT_CREDENTIALS, goauth.GRANT_PASSWORD, goauth.GRANT_REFRESH_TOKEN:
+		default:
+			return errors.New("grant_type " + grantType + " is not supported")
+		}
+	}
+	if request.ExpiredIn < 0 || request.RefreshExpiredIn < 0 {
+		return errors.New("expired_in is invalid")
+	}
+	return nil
+}
+
+func generateClientSecret() (string, string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(buf)
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", err
+	}
+	return secret, string(hash), nil
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"callcenter-api/common/cache"
+	"callcenter-api/common/log"
+	authMdw "callcenter-api/middleware/auth"
+	"callcenter-api/model"
+	"callcenter-api/repository"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrRoleNotFound = errors.New("role is not found")
+
+const permissionCacheTTL = 5 * time.Minute
+
+// defaultPermissions are stored in auth_permissions on start, more can be added
+// straight in the table.
+var defaultPermissions = []model.Permission{
+	{Permission: "agent.read", Description: "view agents"},
+	{Permission: "agent.manage", Description: "create, update and delete agents"},
+	{Permission: "queue.read", Description: "view queues"},
+	{Permission: "queue.manage", Description: "create, update and delete queues"},
+	{Permission: "cdr.read", Description: "view call detail records"},
+	{Permission: "cdr.export", Description: "export call detail records"},
+	{Permission: "recording.read", Description: "listen to and download recordings"},
+	{Permission: "report.read", Description: "view reports"},
+	{Permission: "user.read", Description: "view users"},
+	{Permission: "user.manage", Description: "create, update and delete users"},
+	{Permission: "role.manage", Description: "manage roles and assign them to users"},
+}
+
+type IRole interface {
+	GetUserPermissions(ctx context.Context, domainUuid, userUuid, level string) ([]string, error)
+	GetPermissions(ctx context.Context) (*[]model.Permission, error)
+	GetRoles(ctx context.Context, domainUuid string, limit, offset int) (int, *[]model.Role, error)
+	GetRoleById(ctx context.Context, domainUuid, roleUuid string) (*model.Role, error)
+	CreateRole(ctx context.Context, domainUuid string, request model.RoleRequest) (*model.Role, error)
+	UpdateRole(ctx context.Context, domainUuid string, manageGlobal bool, roleUuid string, request model.RoleRequest) (*model.Role, error)
+	DeleteRole(ctx context.Context, domainUuid string, manageGlobal bool, roleUuid string) error
+	GetUserRoles(ctx context.Context, domainUuid, userUuid string) (*[]model.Role, error)
+	SetUserRoles(ctx context.Context, domainUuid, userUuid string, roleUuids []string) error
+}
+
+type Role struct {
+	// generation is part of the permission cache keys, changing any role or
+	// assignment moves it forward so that stale entries are never read again.
+	generation int64
+}
+
+func NewRole() IRole {
+	if err := repository.RoleRepo.InsertPermissions(context.Background(), &defaultPermissions); err != nil {
+		log.Error(err)
+	}
+	return &Role{}
+}
+
+// GetUserPermissions implements auth.IPermissionResolver. The permissions come from
+// the roles of the user in domainUuid, or from the default roles of its level when it
+// has none, and are cached in cache.MCache.
+func (s *Role) GetUserPermissions(ctx context.Context, domainUuid, userUuid, level string) ([]string, error) {
+	key := fmt.Sprintf("auth_permissions:%d:%s:%s:%s", atomic.LoadInt64(&s.generation), domainUuid, userUuid, level)
+	if cache.MCache != nil {
+		if value, err := cache.MCache.Get(key); err == nil && value != nil {
+			if permissions, ok := value.([]string); ok {
+				return permissions, nil
+			}
+		}
+	}
+	roles, err := repository.RoleRepo.GetUserRoles(ctx, domainUuid, userUuid)
+	if err != nil {
+		return nil, err
+	}
+	if len(*roles) < 1 && len(level) > 0 {
+		roles, err = repository.RoleRepo.GetDefaultRoles(ctx, domainUuid, level)
+		if err != nil {
+			return nil, err
+		}
+	}
+	permissions := []string{}
+	existed := map[string]bool{}
+	for _, role := range *roles {
+		for _, permission := range role.Permissions {
+			if !existed[permission] {
+				existed[permission] = true
+				permissions = append(permissions, permission)
+			}
+		}
+	}
+	if cache.MCache != nil {
+		if err := cache.MCache.SetTTL(key, permissions, permissionCacheTTL); err != nil {
+			log.Error(err)
+		}
+	}
+	return permissions, nil
+}
+
+func (s *Role) invalidate() {
+	atomic.AddInt64(&s.generation, 1)
+}
+
+func (s *Role) GetPermissions(ctx context.Context) (*[]model.Permission, error) {
+	return repository.RoleRepo.GetPermissions(ctx)
+}
+
+func (s *Role) GetRoles(ctx context.Context, domainUuid string, limit, offset int) (int, *[]model.Role, error) {
+	return repository.RoleRepo.GetRoles(ctx, domainUuid, limit, offset)
+}
+
+// GetRoleById returns a role of domainUuid or a shared role.
+func (s *Role) GetRoleById(ctx context.Context, domainUuid, roleUuid string) (*model.Role, error) {
+	role, err := repository.RoleRepo.GetById(ctx, roleUuid)
+	if err != nil {
+		return nil, err
+	} else if role == nil || (len(role.DomainUuid) > 0 && role.DomainUuid != domainUuid) {
+		return nil, ErrRoleNotFound
+	}
+	return role, nil
+}
+
+// CreateRole adds a role to domainUuid, or a shared role when domainUuid is empty.
+func (s *Role) CreateRole(ctx context.Context, domainUuid string, request model.RoleRequest) (*model.Role, error) {
+	if err := s.validateRoleRequest(ctx, request); err != nil {
+		return nil, err
+	}
+	role := &model.Role{
+		RoleUuid:     uuid.NewString(),
+		DomainUuid:   domainUuid,
+		Name:         request.Name,
+		Description:  request.Description,
+		DefaultLevel: request.DefaultLevel,
+		Permissions:  request.Permissions,
+		CreatedAt:    time.Now(),
+		UpdatedAt:    time.Now(),
+	}
+	if err := repository.RoleRepo.Insert(ctx, role); err != nil {
+		return nil, err
+	}
+	s.invalidate()
+	return role, nil
+}
+
+func (s *Role) UpdateRole(ctx context.Context, domainUuid string, manageGlobal bool, roleUuid string, request model.RoleRequest) (*model.Role, error) {
+	if err := s.validateRoleRequest(ctx, request); err != nil {
+		return nil, err
+	}
+	role, err := s.getManagedRole(ctx, domainUuid, manageGlobal, roleUuid)
+	if err != nil {
+		return nil, err
+	}
+	role.Name = request.Name
+	role.Description = request.Description
+	role.DefaultLevel = request.DefaultLevel
+	role.Permissions = request.Permissions
+	role.UpdatedAt = time.Now()
+	if err := repository.RoleRepo.Update(ctx, role); err != nil {
+		return nil, err
+	}
+	s.invalidate()
+	return role, nil
+}
+
+func (s *Role) DeleteRole(ctx context.Context, domainUuid string, manageGlobal bool, roleUuid string) error {
+	if _, err := s.getManagedRole(ctx, domainUuid, manageGlobal, roleUuid); err != nil {
+		return err
+	}
+	if err := repository.RoleRepo.Delete(ctx, roleUuid); err != nil {
+		return err
+	}
+	s.invalidate()
+	return nil
+}
+
+func (s *Role) GetUserRoles(ctx context.Context, domainUuid, userUuid string) (*[]model.Role, error) {
+	return repository.RoleRepo.GetUserRoles(ctx, domainUuid, userUuid)
+}
+
+// SetUserRoles replaces the roles of userUuid, every role must be usable in domainUuid.
+func (s *Role) SetUserRoles(ctx context.Context, domainUuid, userUuid string, roleUuids []string) error {
+	for _, roleUuid := range roleUuids {
+		if _, err := s.GetRoleById(ctx, domainUuid, roleUuid); err != nil {
+			return err
+		}
+	}
+	if err := repository.RoleRepo.SetUserRoles(ctx, domainUuid, userUuid, roleUuids); err != nil {
+		return err
+	}
+	s.invalidate()
+	return nil
+}
+
+// getManagedRole returns a role that may be changed from domainUuid. Shared roles
+// are only changed when manageGlobal is set.
+func (s *Role) getManagedRole(ctx context.Context, domainUuid string, manageGlobal bool, roleUuid string) (*model.Role, error) {
+	role, err := s.GetRoleById(ctx, domainUuid, roleUuid)
+	if err != nil {
+		return nil, err
+	}
+	if len(role.DomainUuid) < 1 && !manageGlobal {
+		return nil, errors.New("shared role can not be changed")
+	}
+	return role, nil
+}
+
+func (s *Role) validateRoleRequest(ctx context.Context, request model.RoleRequest) error {
+	if len(request.Name) < 1 {
+		return errors.New("name is required")
+	}
+	if len(request.DefaultLevel) > 0 && authMdw.LevelRank(request.DefaultLevel) < 1 {
+		return errors.New("default_level is invalid")
+	}
+	permissions, err := repository.RoleRepo.GetPermissions(ctx)
+	if err != nil {
+		return err
+	}
+	for _, permission := range request.Permissions {
+		if !isKnownPermission(*permissions, permission) {
+			return errors.New("permission " + permission + " is not supported")
+		}
+	}
+	return nil
+}
+
+// isKnownPermission accepts a stored permission or a prefix such as cdr.* covering
+// at least one of them.
+func isKnownPermission(permissions []model.Permission, permission string) bool {
+	prefix := ""
+	if strings.HasSuffix(permission, ".*") {
+		prefix = strings.TrimSuffix(permission, "*")
+	}
+	for _, value := range permissions {
+		if value.Permission == permission || (len(prefix) > 0 && strings.HasPrefix(value.Permission, prefix)) {
+			return true
+		}
+	}
+	return false
+}